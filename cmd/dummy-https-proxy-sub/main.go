@@ -9,15 +9,38 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	lg "dummy-https-proxy-sub/internal/logger"
 	"dummy-https-proxy-sub/internal/proxy"
 )
 
-func flagParser() (string, error) {
+type config struct {
+	addr     string
+	services []proxy.ServiceOption
+}
+
+func flagParser() (config, error) {
+	var (
+		cfg    config
+		rename []proxy.RenameRule
+	)
 	portFlag := flag.String("port", "8000", "port to listen on")
+	flag.Func("rename", "default rename rule `pattern@replacement`, may be repeated", func(s string) error {
+		rule, err := proxy.ParseRenameRule(s)
+		if err != nil {
+			return err
+		}
+		rename = append(rename, rule)
+		return nil
+	})
+	nameTemplateFlag := flag.String("name-template", "", "default text/template for proxy names, e.g. {{.Index}}-{{.Name}}")
+	allowInsecure := flag.Bool("allow-insecure", false, "let requests include plain HTTP proxies with the __insecure option")
+	allowAnonymous := flag.Bool("allow-anonymous", false, "serve proxies without username and password")
+	allowTypes := flag.String("allow-types", "", "comma-separated proxy types requests may include with the __types option (socks5, h2)")
 	flag.Parse()
 
 	port := *portFlag
@@ -25,17 +48,43 @@ func flagParser() (string, error) {
 		port = _port
 	}
 	if _, err := strconv.Atoi(port); err != nil {
-		return "", fmt.Errorf("invalid port: %s", port)
+		return cfg, fmt.Errorf("invalid port: %s", port)
+	}
+	cfg.addr = "0.0.0.0:" + port
+
+	var nameTemplate *template.Template
+	if *nameTemplateFlag != "" {
+		var err error
+		if nameTemplate, err = proxy.ParseNameTemplate(*nameTemplateFlag); err != nil {
+			return cfg, err
+		}
+	}
+	var types []string
+	for _, typ := range strings.FieldsFunc(*allowTypes, func(r rune) bool { return r == ',' || r == ' ' }) {
+		typ = strings.ToLower(typ)
+		if typ != proxy.TypeSOCKS5 && typ != proxy.TypeHTTP2 {
+			return cfg, fmt.Errorf("invalid -allow-types entry %q: want %s or %s", typ, proxy.TypeSOCKS5, proxy.TypeHTTP2)
+		}
+		types = append(types, typ)
 	}
-	return "0.0.0.0:" + port, nil
+	cfg.services = append(cfg.services,
+		proxy.WithRename(rename, nameTemplate),
+		proxy.WithPolicy(proxy.Policy{
+			AllowInsecure:  *allowInsecure,
+			AllowAnonymous: *allowAnonymous,
+			AllowTypes:     types,
+		}),
+	)
+	return cfg, nil
 }
 
 func main() {
-	addr, err := flagParser()
+	cfg, err := flagParser()
 	if err != nil {
 		lg.ErrorLogger.Fatal(err)
 	}
-	handler := proxy.NewHandler(proxy.NewService(http.DefaultClient))
+	addr := cfg.addr
+	handler := proxy.NewHandler(proxy.NewService(http.DefaultClient, cfg.services...))
 	server := &http.Server{Addr: addr, Handler: handler}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+
+	goyaml "github.com/goccy/go-yaml"
+)
+
+// clashProxy mirrors the subset of a mihomo `type: http` proxy we emit.
+type clashProxy struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	TLS      bool   `yaml:"tls"`
+	SNI      string `yaml:"sni,omitempty"`
+
+	SkipCertVerify    bool     `yaml:"skip-cert-verify,omitempty"`
+	Fingerprint       string   `yaml:"fingerprint,omitempty"`
+	ALPN              []string `yaml:"alpn,omitempty"`
+	ClientFingerprint string   `yaml:"client-fingerprint,omitempty"`
+
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+type clashDocument struct {
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups,omitempty"`
+}
+
+// encodeClash renders items as a Clash YAML document. When group is not
+// empty a `select` group of that name listing every proxy is appended.
+func encodeClash(items []ProxyItem, group string) (string, error) {
+	doc := clashDocument{Proxies: make([]clashProxy, 0, len(items))}
+	for _, it := range items {
+		p := clashProxy{
+			Name:     displayName(it),
+			Type:     it.Type,
+			Server:   it.Server,
+			Port:     it.Port,
+			Username: it.Username,
+			Password: it.Password,
+			TLS:      it.TLS,
+			SNI:      it.SNI,
+			Headers:  it.Headers,
+		}
+		if it.TLS {
+			p.SkipCertVerify, p.Fingerprint = it.SkipCertVerify, it.Fingerprint
+			p.ALPN, p.ClientFingerprint = it.ALPN, it.ClientFingerprint
+		}
+		if it.Type == TypeHTTP2 {
+			p.Type, p.ALPN = TypeHTTP, []string{"h2"}
+		}
+		doc.Proxies = append(doc.Proxies, p)
+	}
+	if group != "" {
+		names := make([]string, 0, len(doc.Proxies))
+		for _, p := range doc.Proxies {
+			names = append(names, p.Name)
+		}
+		doc.ProxyGroups = []clashProxyGroup{{Name: group, Type: "select", Proxies: names}}
+	}
+
+	out, err := goyaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal clash document: %v", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// endpointKey identifies the endpoint a proxy connects to, regardless of
+// its display name.
+type endpointKey struct {
+	typ, server, username, password, sni, headers string
+	port                                          int
+	tls                                           bool
+}
+
+// dedupProxies drops every item whose type, TLS setting, server, port,
+// credentials, SNI and headers were already seen earlier in the list.
+func dedupProxies(items []ProxyItem) []ProxyItem {
+	seen := make(map[endpointKey]bool, len(items))
+	result := make([]ProxyItem, 0, len(items))
+	for _, it := range items {
+		key := endpointKey{
+			typ:      it.Type,
+			tls:      it.TLS,
+			server:   strings.ToLower(it.Server),
+			username: it.Username,
+			password: it.Password,
+			sni:      strings.ToLower(it.SNI),
+			headers:  headersKey(it.Headers),
+			port:     it.Port,
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, it)
+	}
+	return result
+}
+
+// headersKey serializes headers in a stable order, header names compared
+// case-insensitively.
+func headersKey(headers map[string]string) string {
+	lines := make([]string, 0, len(headers))
+	for k, v := range headers {
+		lines = append(lines, strings.ToLower(k)+": "+v)
+	}
+	slices.Sort(lines)
+	return strings.Join(lines, "\n")
+}
+
+// uniqueNames suffixes repeated names with " 2", " 3", ... so that every
+// item ends up with a distinct name. items is modified in place.
+func uniqueNames(items []ProxyItem) {
+	used := make(map[string]bool, len(items))
+	for _, it := range items {
+		used[displayName(it)] = true
+	}
+	taken := make(map[string]bool, len(items))
+	for i := range items {
+		name := displayName(items[i])
+		if !taken[name] {
+			taken[name] = true
+			continue
+		}
+		for n := 2; ; n++ {
+			candidate := name + " " + strconv.Itoa(n)
+			if !taken[candidate] && !used[candidate] {
+				items[i].Name = candidate
+				taken[candidate] = true
+				break
+			}
+		}
+	}
+}
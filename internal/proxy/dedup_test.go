@@ -0,0 +1,49 @@
+package proxy
+
+import "testing"
+
+func TestDedupProxies(t *testing.T) {
+	items := []ProxyItem{
+		{Name: "HK Premium", Server: "a.example", Port: 443, Username: "u", Password: "p", SNI: "s.example"},
+		{Name: "HK Unlimited", Server: "A.example", Port: 443, Username: "u", Password: "p", SNI: "s.example"},
+		{Name: "HK other user", Server: "a.example", Port: 443, Username: "v", Password: "p", SNI: "s.example"},
+		{Name: "HK other port", Server: "a.example", Port: 8443, Username: "u", Password: "p", SNI: "s.example"},
+		{Name: "HK token", Server: "a.example", Port: 443, Username: "u", Password: "p", SNI: "s.example", Headers: map[string]string{"X-Token": "t"}},
+		{Name: "HK same token", Server: "a.example", Port: 443, Username: "u", Password: "p", SNI: "s.example", Headers: map[string]string{"x-token": "t"}},
+	}
+	for i := range items {
+		items[i].Type, items[i].TLS = TypeHTTP, true
+	}
+	items = append(items,
+		ProxyItem{Name: "HK socks", Type: TypeSOCKS5, TLS: true, Server: "a.example", Port: 443, Username: "u", Password: "p", SNI: "s.example"},
+		ProxyItem{Name: "HK plain", Type: TypeHTTP, Server: "a.example", Port: 443, Username: "u", Password: "p", SNI: "s.example"},
+	)
+
+	got := dedupProxies(items)
+	if len(got) != 6 {
+		t.Fatalf("want 6 unique proxies, got %d: %+v", len(got), got)
+	}
+	if got[0].Name != "HK Premium" || got[1].Name != "HK other user" || got[2].Name != "HK other port" || got[3].Name != "HK token" || got[4].Name != "HK socks" || got[5].Name != "HK plain" {
+		t.Fatalf("unexpected order or selection: %+v", got)
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	items := []ProxyItem{
+		{Name: "HK"},
+		{Name: "HK"},
+		{Name: "HK 2"},
+		{Name: "HK"},
+		{Server: "a.example", Port: 443},
+		{Server: "a.example", Port: 443},
+	}
+
+	uniqueNames(items)
+
+	want := []string{"HK", "HK 3", "HK 2", "HK 4", "", "a.example:443 2"}
+	for i, it := range items {
+		if it.Name != want[i] {
+			t.Fatalf("item %d: want %q got %q", i, want[i], it.Name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	lg "dummy-https-proxy-sub/internal/logger"
+)
+
+// Reason classifies a Diagnostic.
+type Reason string
+
+const (
+	// ReasonMalformed marks an entry the parser could not read.
+	ReasonMalformed           Reason = "malformed"
+	ReasonBadPort             Reason = "bad-port"
+	ReasonMissingServer       Reason = "missing-server"
+	ReasonMissingCredentials  Reason = "missing-credentials"
+	ReasonMissingUsername     Reason = "missing-username"
+	ReasonMissingPassword     Reason = "missing-password"
+	ReasonInsecure            Reason = "insecure"
+	ReasonUnsupportedType     Reason = "unsupported-type"
+	ReasonUnsupportedByFormat Reason = "unsupported-by-format"
+	// ReasonSourceFailed marks a merge source or proxy-provider that could
+	// not be fetched or parsed, and whose entries are all missing.
+	ReasonSourceFailed Reason = "source-failed"
+	// ReasonDroppedField marks a served entry that lost a setting the output
+	// format cannot express.
+	ReasonDroppedField Reason = "dropped-field"
+)
+
+// Diagnostic reports an upstream entry that was skipped, or served with a
+// caveat when Skipped is false.
+type Diagnostic struct {
+	// Source is the URL of the upstream document or proxy-provider holding
+	// the entry. It is empty when a parser is used directly.
+	Source string `json:"source,omitempty"`
+	// Index is the position of the entry in Source, starting at 0, or -1
+	// when the diagnostic is about Source as a whole.
+	Index   int    `json:"index"`
+	Name    string `json:"name,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Reason  Reason `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+	Skipped bool   `json:"skipped"`
+}
+
+func (d Diagnostic) Error() string {
+	var sb strings.Builder
+	if d.Source != "" {
+		sb.WriteString(d.Source + ": ")
+	}
+	if d.Index >= 0 {
+		fmt.Fprintf(&sb, "entry %d", d.Index)
+	} else {
+		sb.WriteString("source")
+	}
+	if d.Name != "" {
+		fmt.Fprintf(&sb, " %q", d.Name)
+	}
+	sb.WriteString(": ")
+	sb.WriteString(string(d.Reason))
+	if d.Field != "" {
+		sb.WriteString(" (" + d.Field + ")")
+	}
+	if d.Detail != "" {
+		sb.WriteString(": " + d.Detail)
+	}
+	return sb.String()
+}
+
+// entryDiagnostic describes entry index, which could not be parsed because
+// of err. A Diagnostic wrapped by err supplies the reason and field.
+func entryDiagnostic(index int, name string, err error) Diagnostic {
+	var d Diagnostic
+	if !errors.As(err, &d) {
+		d = Diagnostic{Reason: ReasonMalformed, Detail: err.Error()}
+	}
+	d.Index, d.Name, d.Skipped = index, name, true
+	return d
+}
+
+// keyError reports that the value of key could not be parsed. Port keys,
+// port in Clash and URIs and server_port in sing-box, report bad-port.
+func keyError(key string, err error) Diagnostic {
+	reason := ReasonMalformed
+	if key == "port" || key == "server_port" {
+		reason = ReasonBadPort
+	}
+	return Diagnostic{Field: key, Reason: reason, Detail: err.Error()}
+}
+
+// sourceDiagnostic reports that the document at source, the proxy-provider
+// name if set, could not be used because of err.
+func sourceDiagnostic(source, name string, err error) Diagnostic {
+	return Diagnostic{Source: source, Index: -1, Name: name, Reason: ReasonSourceFailed, Detail: err.Error(), Skipped: true}
+}
+
+// proxyDiagnostic describes a problem with the parsed item it.
+func proxyDiagnostic(it ProxyItem, reason Reason, field, detail string, skipped bool) Diagnostic {
+	return Diagnostic{Source: it.source, Index: it.index, Name: it.Name, Field: field, Reason: reason, Detail: detail, Skipped: skipped}
+}
+
+// diagnosticsHeader summarizes the diagnostics of a response as
+// comma-separated reason=count pairs.
+const diagnosticsHeader = "X-Proxy-Diagnostics"
+
+// diagnosticCounts counts diags by reason.
+func diagnosticCounts(diags []Diagnostic) map[Reason]int {
+	counts := make(map[Reason]int)
+	for _, d := range diags {
+		counts[d.Reason]++
+	}
+	return counts
+}
+
+// formatCounts renders counts for diagnosticsHeader, ordered by reason.
+func formatCounts(counts map[Reason]int) string {
+	pairs := make([]string, 0, len(counts))
+	for reason, n := range counts {
+		pairs = append(pairs, string(reason)+"="+strconv.Itoa(n))
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// debugReport is the body served instead of the subscription when a request
+// sets the debug option.
+type debugReport struct {
+	Proxies     int            `json:"proxies"`
+	Error       string         `json:"error,omitempty"`
+	Counts      map[Reason]int `json:"counts"`
+	Diagnostics []Diagnostic   `json:"diagnostics"`
+}
+
+// encodeDebugReport renders the diagnostics of result, and err when the
+// request produced no proxies, as indented JSON.
+func encodeDebugReport(result Result, err error) (string, error) {
+	report := debugReport{
+		Proxies:     result.Proxies,
+		Counts:      diagnosticCounts(result.Diagnostics),
+		Diagnostics: result.Diagnostics,
+	}
+	if report.Diagnostics == nil {
+		report.Diagnostics = []Diagnostic{}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal debug report: %v", err)
+	}
+	return string(out) + "\n", nil
+}
+
+// logDiagnostics writes diags to the warning log.
+func logDiagnostics(diags []Diagnostic) {
+	for _, d := range diags {
+		if d.Reason == ReasonSourceFailed {
+			lg.WarnLogger.Printf("skipped source: %v", d)
+		} else if d.Skipped {
+			lg.WarnLogger.Printf("skipped proxy: %v", d)
+		} else {
+			lg.WarnLogger.Printf("served proxy with caveat: %v", d)
+		}
+	}
+}
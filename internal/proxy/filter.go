@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// filterProxies keeps the items whose name or server matches include (when
+// set) and matches neither against exclude (when set).
+func filterProxies(items []ProxyItem, include, exclude *regexp.Regexp) []ProxyItem {
+	if include == nil && exclude == nil {
+		return items
+	}
+	matches := func(re *regexp.Regexp, it ProxyItem) bool {
+		return re.MatchString(it.Name) || re.MatchString(it.Server)
+	}
+
+	result := make([]ProxyItem, 0, len(items))
+	for _, it := range items {
+		if include != nil && !matches(include, it) {
+			continue
+		}
+		if exclude != nil && matches(exclude, it) {
+			continue
+		}
+		result = append(result, it)
+	}
+	return result
+}
+
+// regexpOption compiles the named option. Repeated values are combined as
+// alternatives; an absent option yields nil.
+func regexpOption(values url.Values, key string) (*regexp.Regexp, error) {
+	var exprs []string
+	for _, v := range values[key] {
+		if v != "" {
+			exprs = append(exprs, "(?:"+v+")")
+		}
+	}
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	re, err := regexp.Compile(strings.Join(exprs, "|"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: option %s%s: %v", ErrInvalidInput, optionPrefix, key, err)
+	}
+	return re, nil
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestFilterProxies(t *testing.T) {
+	items := []ProxyItem{
+		{Name: "HK 01", Server: "a.example"},
+		{Name: "JP 01 expire 2026-01-01", Server: "b.example"},
+		{Name: "US 01", Server: "hk-relay.example"},
+		{Name: "SG 01", Server: "c.example"},
+	}
+
+	values := url.Values{"include": {"HK|JP", "(?i)relay"}, "exclude": {"expire"}}
+	include, err := regexpOption(values, "include")
+	if err != nil {
+		t.Fatalf("include: %v", err)
+	}
+	exclude, err := regexpOption(values, "exclude")
+	if err != nil {
+		t.Fatalf("exclude: %v", err)
+	}
+
+	got := filterProxies(items, include, exclude)
+	if len(got) != 2 || got[0].Name != "HK 01" || got[1].Name != "US 01" {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+
+	if got := filterProxies(items, nil, nil); len(got) != len(items) {
+		t.Fatalf("nil filters must keep everything, got %d", len(got))
+	}
+
+	if _, err := regexpOption(url.Values{"include": {"("}}, "include"); err == nil {
+		t.Fatalf("expected error for invalid expression")
+	}
+}
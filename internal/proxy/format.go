@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Format selects how the processed proxies are rendered for the client.
+type Format string
+
+const (
+	// FormatBase64 is the classic base64-encoded list of https:// URIs.
+	FormatBase64 Format = "base64"
+	// FormatClash is a minimal Clash/mihomo YAML document.
+	FormatClash Format = "clash"
+	// FormatSingbox is a sing-box JSON document listing `http` outbounds.
+	FormatSingbox Format = "singbox"
+	// FormatSurge is a Surge policy file of `Name = https, ...` lines.
+	FormatSurge Format = "surge"
+	// FormatLoon is the Loon flavour of FormatSurge.
+	FormatLoon Format = "loon"
+	// FormatQuanX is a Quantumult X `server_remote` resource.
+	FormatQuanX Format = "quanx"
+	// FormatPAC is a Proxy Auto-Config script for browsers.
+	FormatPAC Format = "pac"
+)
+
+// ParseFormat maps a user supplied format name onto a Format. An empty name
+// yields FormatBase64.
+func ParseFormat(s string) (Format, error) {
+	switch name := strings.ToLower(strings.TrimSpace(s)); name {
+	case "", string(FormatBase64):
+		return FormatBase64, nil
+	case string(FormatClash), "mihomo", "yaml":
+		return FormatClash, nil
+	case string(FormatSingbox), "sing-box", "json":
+		return FormatSingbox, nil
+	case string(FormatSurge):
+		return FormatSurge, nil
+	case string(FormatLoon):
+		return FormatLoon, nil
+	case string(FormatQuanX), "quantumultx", "qx":
+		return FormatQuanX, nil
+	case string(FormatPAC):
+		return FormatPAC, nil
+	default:
+		return "", fmt.Errorf("%w: unknown format %q", ErrInvalidInput, s)
+	}
+}
+
+// formatFromAccept returns the format an Accept header prefers: the one
+// with the highest q-value, the first listed on a tie. Media types with
+// q=0 are refused. text/plain stands for FormatBase64, so a client can
+// prefer it over the others. application/json is deliberately not mapped
+// to sing-box: generic HTTP clients send it by default.
+func formatFromAccept(accept string) (Format, bool) {
+	var (
+		best  Format
+		bestQ float64
+	)
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var f Format
+		switch mediaType {
+		case "text/plain":
+			f = FormatBase64
+		case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+			f = FormatClash
+		case "application/x-ns-proxy-autoconfig":
+			f = FormatPAC
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, best != ""
+}
+
+// contentType reports the Content-Type header matching the format.
+func (f Format) contentType() string {
+	switch f {
+	case FormatClash:
+		return "application/yaml; charset=utf-8"
+	case FormatSingbox:
+		return "application/json; charset=utf-8"
+	case FormatPAC:
+		return "application/x-ns-proxy-autoconfig"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// supports reports whether f can express it. Every format handles TypeHTTP;
+// the other types only where the target client has a native equivalent.
+func (f Format) supports(it ProxyItem) bool {
+	if f == "" {
+		f = FormatBase64
+	}
+	switch it.Type {
+	case TypeHTTP:
+		return true
+	case TypeSOCKS5:
+		switch f {
+		case FormatBase64, FormatClash, FormatSurge, FormatQuanX:
+			return true
+		}
+	case TypeHTTP2:
+		// Browsers negotiate HTTP/2 with HTTPS proxies on their own, and
+		// mihomo takes it from an h2-only alpn.
+		return (f == FormatBase64 || f == FormatPAC || f == FormatClash) && it.TLS
+	}
+	return false
+}
+
+// expressibleProxies drops the items f cannot express, along with a
+// diagnostic for each of them.
+func expressibleProxies(items []ProxyItem, f Format) ([]ProxyItem, []Diagnostic) {
+	result, diags := make([]ProxyItem, 0, len(items)), []Diagnostic(nil)
+	for _, it := range items {
+		if !f.supports(it) {
+			diags = append(diags, proxyDiagnostic(it, ReasonUnsupportedByFormat, "type", fmt.Sprintf("%s output cannot express %s proxies", f, it.Type), true))
+			continue
+		}
+		result = append(result, it)
+	}
+	return result, diags
+}
+
+// droppedFields reports the settings of items that f renders without:
+// CONNECT headers outside Clash and sing-box (Surge has no documented
+// parameter for them either), certificate fingerprints in
+// sing-box, and ALPN and client fingerprints in the Surge, Loon and
+// Quantumult X lines. PAC output carries no credentials either and is
+// exempt.
+func droppedFields(items []ProxyItem, f Format) []Diagnostic {
+	var diags []Diagnostic
+	policyLines := f == FormatSurge || f == FormatLoon || f == FormatQuanX
+	for _, it := range items {
+		if len(it.Headers) > 0 && f != FormatClash && f != FormatSingbox && f != FormatPAC {
+			diags = append(diags, proxyDiagnostic(it, ReasonDroppedField, "headers", fmt.Sprintf("%s output cannot express CONNECT headers", f), false))
+		}
+		if it.TLS && it.Fingerprint != "" && f == FormatSingbox {
+			diags = append(diags, proxyDiagnostic(it, ReasonDroppedField, "fingerprint", "sing-box cannot pin a certificate fingerprint", false))
+		}
+		if it.TLS && len(it.ALPN) > 0 && policyLines {
+			diags = append(diags, proxyDiagnostic(it, ReasonDroppedField, "alpn", fmt.Sprintf("%s output cannot set ALPN", f), false))
+		}
+		if it.TLS && it.ClientFingerprint != "" && policyLines {
+			diags = append(diags, proxyDiagnostic(it, ReasonDroppedField, "client-fingerprint", fmt.Sprintf("%s output cannot mimic a client fingerprint", f), false))
+		}
+	}
+	return diags
+}
+
+// render encodes validated items in the format requested by opts.
+func render(items []ProxyItem, opts Options) (string, error) {
+	switch opts.Format {
+	case FormatClash:
+		return encodeClash(items, opts.ClashGroup)
+	case FormatSingbox:
+		return encodeSingbox(items)
+	case FormatSurge:
+		return encodeSurge(items), nil
+	case FormatLoon:
+		return encodeLoon(items), nil
+	case FormatQuanX:
+		return encodeQuanX(items), nil
+	case FormatPAC:
+		return encodePAC(items, opts.PACInclude, opts.PACExclude)
+	default:
+		return encodeBase64(items), nil
+	}
+}
+
+// encodeBase64 renders items as a base64 encoded list of https:// URIs.
+func encodeBase64(items []ProxyItem) string {
+	lines, bufSize := make([]string, 0, len(items)), 0
+	for _, it := range items {
+		line := craftURL(it)
+		lines = append(lines, line)
+		bufSize += len(line) + 1
+	}
+	return base64Encode(lines, bufSize)
+}
+
+// displayName returns the item name, falling back to its address so formats
+// that require a name always get one.
+func displayName(it ProxyItem) string {
+	if it.Name != "" {
+		return it.Name
+	}
+	return net.JoinHostPort(it.Server, strconv.Itoa(it.Port))
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestRenderPolicyLines(t *testing.T) {
+	items := []ProxyItem{
+		{Name: "HK, 01", Server: "a.example", Port: 443, Username: "u", Password: "p,=q", TLS: true, Type: "http", SNI: "sni.example"},
+		{Server: "b.example", Port: 8443, Username: "u", Password: "p", TLS: true, Type: "http"},
+	}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: FormatSurge,
+			want: "HK  01 = https, a.example, 443, u, \"p,=q\", sni=sni.example\n" +
+				"b.example:8443 = https, b.example, 8443, u, p\n",
+		},
+		{
+			format: FormatLoon,
+			want: "HK  01 = https,a.example,443,u,\"p,=q\",sni=sni.example\n" +
+				"b.example:8443 = https,b.example,8443,u,\"p\"\n",
+		},
+		{
+			format: FormatQuanX,
+			want: "http=a.example:443, username=u, password=\"p,=q\", over-tls=true, tls-host=sni.example, tag=HK  01\n" +
+				"http=b.example:8443, username=u, password=p, over-tls=true, tag=b.example:8443\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			got, err := render(items, Options{Format: tt.format})
+			if err != nil {
+				t.Fatalf("render returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("unexpected output:\nwant %q\ngot  %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]Format{
+		"":         FormatBase64,
+		"Base64":   FormatBase64,
+		"mihomo":   FormatClash,
+		"sing-box": FormatSingbox,
+		"surge":    FormatSurge,
+		"loon":     FormatLoon,
+		"qx":       FormatQuanX,
+	}
+	for in, want := range tests {
+		got, err := ParseFormat(in)
+		if err != nil {
+			t.Fatalf("ParseFormat(%q) returned error: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("ParseFormat(%q): want %q got %q", in, want, got)
+		}
+	}
+	if _, err := ParseFormat("nope"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
+
+func TestRenderPAC(t *testing.T) {
+	items := []ProxyItem{
+		{Name: "first", Server: "a.example", Port: 443, Username: "u", Password: "p", TLS: true, Type: "http"},
+		{Name: "second", Server: "2001:db8::1", Port: 8443, Username: "u", Password: "p", TLS: true, Type: "http"},
+	}
+
+	got, err := render(items, Options{
+		Format:     FormatPAC,
+		PACInclude: []string{"*.Google.com", "github.com."},
+		PACExclude: []string{"intranet.example"},
+	})
+	if err != nil {
+		t.Fatalf("render returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`var proxies = "HTTPS a.example:443; HTTPS [2001:db8::1]:8443";`,
+		`var include = ["google.com","github.com"];`,
+		`var exclude = ["intranet.example"];`,
+		"function FindProxyForURL(url, host) {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("PAC output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderAnonymous(t *testing.T) {
+	items := []ProxyItem{{Name: "open", Server: "a.example", Port: 443, TLS: true, Type: "http"}}
+
+	tests := map[Format]string{
+		FormatSurge: "open = https, a.example, 443\n",
+		FormatLoon:  "open = https,a.example,443\n",
+		FormatQuanX: "http=a.example:443, over-tls=true, tag=open\n",
+	}
+	for format, want := range tests {
+		got, err := render(items, Options{Format: format})
+		if err != nil {
+			t.Fatalf("%s: render returned error: %v", format, err)
+		}
+		if got != want {
+			t.Fatalf("%s: want %q got %q", format, want, got)
+		}
+	}
+}
+
+func TestRenderProxyTypes(t *testing.T) {
+	socks := ProxyItem{Name: "socks", Server: "s.example", Port: 1080, Username: "u", Password: "p", TLS: true, Type: TypeSOCKS5, SNI: "sni.example"}
+	h2 := ProxyItem{Name: "h2", Server: "h.example", Port: 443, Username: "u", Password: "p", TLS: true, Type: TypeHTTP2}
+
+	tests := []struct {
+		format Format
+		item   ProxyItem
+		want   string
+	}{
+		{format: FormatBase64, item: socks, want: "socks5+tls://u:p@s.example:1080?sni=sni.example#socks"},
+		{format: FormatBase64, item: h2, want: "h2://u:p@h.example:443#h2"},
+		{format: FormatSurge, item: socks, want: "socks = socks5-tls, s.example, 1080, u, p, sni=sni.example\n"},
+		{format: FormatQuanX, item: socks, want: "socks5=s.example:1080, username=u, password=p, over-tls=true, tls-host=sni.example, tag=socks\n"},
+		{format: FormatPAC, item: h2, want: `var proxies = "HTTPS h.example:443";`},
+		{format: FormatClash, item: h2, want: "type: http\n  server: h.example\n  port: 443\n  username: u\n  password: p\n  tls: true\n  alpn:\n  - h2\n"},
+		{format: FormatSurge, item: h2},
+		{format: FormatSingbox, item: socks},
+		{format: FormatLoon, item: socks},
+		{format: FormatPAC, item: socks},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format)+"/"+tt.item.Type, func(t *testing.T) {
+			items, _ := expressibleProxies([]ProxyItem{tt.item}, tt.format)
+			if tt.want == "" {
+				if len(items) != 0 {
+					t.Fatalf("%s output should skip %s proxies", tt.format, tt.item.Type)
+				}
+				return
+			}
+			if len(items) != 1 {
+				t.Fatalf("%s output should keep %s proxies", tt.format, tt.item.Type)
+			}
+			got := craftURL(items[0])
+			if tt.format != FormatBase64 {
+				var err error
+				if got, err = render(items, Options{Format: tt.format}); err != nil {
+					t.Fatalf("render returned error: %v", err)
+				}
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Fatalf("want %q in output, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderTLSOptions(t *testing.T) {
+	items := []ProxyItem{{
+		Name: "hk", Server: "a.example", Port: 443, Username: "u", Password: "p", TLS: true, Type: TypeHTTP,
+		SkipCertVerify: true, Fingerprint: "abcd", ALPN: []string{"h2"}, ClientFingerprint: "chrome",
+	}}
+
+	tests := []struct {
+		format  Format
+		want    []string
+		dropped []string
+	}{
+		{format: FormatClash, want: []string{"skip-cert-verify: true", "fingerprint: abcd", "- h2", "client-fingerprint: chrome"}},
+		{format: FormatSingbox, want: []string{`"insecure": true`, `"alpn": [`, `"fingerprint": "chrome"`}, dropped: []string{"fingerprint"}},
+		{format: FormatSurge, want: []string{", skip-cert-verify=true, server-cert-fingerprint-sha256=abcd\n"}, dropped: []string{"alpn", "client-fingerprint"}},
+		{format: FormatLoon, want: []string{",skip-cert-verify=true,tls-cert-sha256=abcd\n"}, dropped: []string{"alpn", "client-fingerprint"}},
+		{format: FormatQuanX, want: []string{", tls-verification=false, tls-cert-sha256=abcd, "}, dropped: []string{"alpn", "client-fingerprint"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			got, err := render(items, Options{Format: tt.format})
+			if err != nil {
+				t.Fatalf("render returned error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Fatalf("want %q in output, got %q", want, got)
+				}
+			}
+
+			var dropped []string
+			for _, d := range droppedFields(items, tt.format) {
+				if d.Reason != ReasonDroppedField || d.Skipped {
+					t.Fatalf("unexpected diagnostic: %+v", d)
+				}
+				dropped = append(dropped, d.Field)
+			}
+			if strings.Join(dropped, ",") != strings.Join(tt.dropped, ",") {
+				t.Fatalf("want dropped fields %v, got %v", tt.dropped, dropped)
+			}
+		})
+	}
+}
+
+func TestRenderHeaders(t *testing.T) {
+	sub, err := ParseClashFromReader(strings.NewReader(`proxies:
+- name: hk
+  type: http
+  server: a.example
+  port: 443
+  username: u
+  password: p
+  tls: true
+  headers:
+    X-Token: secret
+`))
+	if err != nil {
+		t.Fatalf("ParseClashFromReader returned error: %v", err)
+	}
+	if got := sub.Proxies[0].Headers["X-Token"]; got != "secret" {
+		t.Fatalf("want header X-Token=secret, got %v", sub.Proxies[0].Headers)
+	}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{format: FormatClash, want: "headers:\n    X-Token: secret\n"},
+		{format: FormatSingbox, want: "\"headers\": {\n        \"X-Token\": \"secret\"\n      }"},
+		{format: FormatBase64},
+		// Surge has no documented parameter for CONNECT headers.
+		{format: FormatSurge},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			got, err := render(sub.Proxies, Options{Format: tt.format})
+			if err != nil {
+				t.Fatalf("render returned error: %v", err)
+			}
+			if tt.format == FormatBase64 {
+				decoded, _ := base64.StdEncoding.DecodeString(got)
+				got = string(decoded)
+			}
+			if tt.want == "" {
+				if strings.Contains(got, "secret") {
+					t.Fatalf("%s output cannot carry headers, got %q", tt.format, got)
+				}
+				if diags := droppedFields(sub.Proxies, tt.format); len(diags) != 1 || diags[0].Field != "headers" {
+					t.Fatalf("want the dropped headers reported, got %+v", diags)
+				}
+				return
+			}
+			if diags := droppedFields(sub.Proxies, tt.format); len(diags) != 0 {
+				t.Fatalf("%s output carries headers, got %+v", tt.format, diags)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Fatalf("want %q in output, got %q", tt.want, got)
+			}
+		})
+	}
+}
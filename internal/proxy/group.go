@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	lg "dummy-https-proxy-sub/internal/logger"
+
+	goyaml "github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+)
+
+// ProxyGroup is a Clash proxy-group. Proxies may name inline proxies or
+// other groups; Use names proxy-providers whose proxies all belong to it.
+type ProxyGroup struct {
+	Name    string
+	Proxies []string
+	Use     []string
+}
+
+// parseGroups extracts $.proxy-groups from f.
+func parseGroups(f *ast.File) ([]ProxyGroup, error) {
+	node, err := lookupNode(f, "proxy-groups")
+	if errors.Is(err, goyaml.ErrNotFoundNode) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy-groups: %v", err)
+	}
+	seq, ok := node.(ast.ArrayNode)
+	if !ok {
+		return nil, fmt.Errorf("proxy-groups must be a sequence, got %T", node)
+	}
+
+	var result []ProxyGroup
+	for iter := seq.ArrayRange(); iter.Next(); {
+		mnode, ok := iter.Value().(*ast.MappingNode)
+		if !ok {
+			return nil, fmt.Errorf("proxy group not a mapping, got %T", iter.Value())
+		}
+		var g ProxyGroup
+		for miter := mnode.MapRange(); miter.Next(); {
+			k, v := strings.TrimSpace(miter.Key().String()), miter.Value()
+			switch k {
+			case "name":
+				g.Name, err = nodeToString(v)
+			case "proxies":
+				g.Proxies, err = nodeToStringList(v)
+			case "use":
+				g.Use, err = nodeToStringList(v)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed while parsing the key %s of proxy group: %v", k, err)
+			}
+		}
+		if g.Name == "" {
+			lg.WarnLogger.Printf("skipped proxy group without name")
+			continue
+		}
+		result = append(result, g)
+	}
+	return result, nil
+}
+
+// selectGroup returns the proxies reachable from the named group, expanding
+// nested groups and providers in declaration order. Each proxy is returned
+// at most once; built-in policies such as DIRECT are ignored.
+func (sub Subscription) selectGroup(name string) ([]ProxyItem, error) {
+	groups := make(map[string]ProxyGroup, len(sub.Groups))
+	for _, g := range sub.Groups {
+		groups[g.Name] = g
+	}
+	if _, ok := groups[name]; !ok {
+		return nil, fmt.Errorf("%w: unknown proxy group %q", ErrInvalidInput, name)
+	}
+	proxies := make(map[string]int, len(sub.Proxies))
+	for i, it := range sub.Proxies {
+		if _, ok := proxies[it.Name]; !ok {
+			proxies[it.Name] = i
+		}
+	}
+	providers := make(map[string]int, len(sub.Providers))
+	for i, p := range sub.Providers {
+		providers[p.Name] = i
+	}
+
+	var (
+		result       []ProxyItem
+		seenProxy    = map[int]bool{}
+		seenProvider = map[int]bool{}
+		visited      = map[string]bool{}
+		expand       func(g ProxyGroup)
+	)
+	expand = func(g ProxyGroup) {
+		if visited[g.Name] {
+			return
+		}
+		visited[g.Name] = true
+		for _, member := range g.Proxies {
+			if i, ok := proxies[member]; ok {
+				if !seenProxy[i] {
+					seenProxy[i] = true
+					result = append(result, sub.Proxies[i])
+				}
+			} else if nested, ok := groups[member]; ok {
+				expand(nested)
+			}
+		}
+		for _, use := range g.Use {
+			i, ok := providers[use]
+			if !ok {
+				lg.WarnLogger.Printf("proxy group %s uses unknown provider %s", g.Name, use)
+				continue
+			}
+			if !seenProvider[i] {
+				seenProvider[i] = true
+				result = append(result, sub.Providers[i].Proxies...)
+			}
+		}
+	}
+	expand(groups[name])
+	return result, nil
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"errors"
 	"net/http"
-	"strings"
+	"strconv"
 
 	lg "dummy-https-proxy-sub/internal/logger"
 )
 
+// insecureHeader reports how many plain HTTP proxies a response includes.
+const insecureHeader = "X-Insecure-Proxies"
+
 // Processor captures the behaviour required by the HTTP handler.
 type Processor interface {
-	Process(ctx context.Context, targetURL string) (string, error)
+	Process(ctx context.Context, targetURL string, opts Options) (Result, error)
 }
 
 // Handler routes incoming HTTP requests through the provided Processor.
@@ -27,39 +30,76 @@ func NewHandler(processor Processor) *Handler {
 	return &Handler{processor: processor}
 }
 
-// ServeHTTP extracts the target URL from the request path, processes it, and delivers the base64 payload.
+// ServeHTTP extracts the target URL and service options from the request, processes it, and delivers the
+// rendered payload.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h == nil || h.processor == nil {
 		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Trimming the leading slash yields the embedded target URL.
-	target := strings.TrimPrefix(r.URL.EscapedPath(), "/")
-	if r.URL.RawQuery != "" {
-		target += "?" + r.URL.RawQuery
+	target, opts, err := parseRequest(r)
+	if err != nil {
+		writeError(w, target, err)
+		return
 	}
 
-	encoded, err := h.processor.Process(r.Context(), target)
+	result, err := h.processor.Process(r.Context(), target, opts)
+	if opts.Debug && (err == nil || errors.Is(err, ErrNoValidProxies)) {
+		writeDebug(w, result, err)
+		return
+	}
+	// Set even when nothing could be served: that is when a client most
+	// needs to know why.
+	if len(result.Diagnostics) > 0 {
+		w.Header().Set(diagnosticsHeader, formatCounts(diagnosticCounts(result.Diagnostics)))
+	}
 	if err != nil {
-		status := statusFromError(err)
-		message := http.StatusText(status)
-		if message == "" {
-			message = "unexpected error"
-		}
-		http.Error(w, message, status)
-
-		lg.ErrorLogger.Printf("request failed: target=%q status=%d error=%v", target, status, err)
+		writeError(w, target, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	contentType := result.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if result.Insecure > 0 {
+		w.Header().Set(insecureHeader, strconv.Itoa(result.Insecure))
+	}
 	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(encoded)); err != nil {
+	if _, err := w.Write([]byte(result.Body)); err != nil {
 		lg.ErrorLogger.Printf("failed to write response: %v", err)
 	}
 }
 
+// writeDebug serves the diagnostics of result as JSON. err, if any, is the
+// ErrNoValidProxies of a request that produced nothing to serve.
+func writeDebug(w http.ResponseWriter, result Result, err error) {
+	body, err := encodeDebugReport(result, err)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		lg.ErrorLogger.Printf("request failed: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(body)); err != nil {
+		lg.ErrorLogger.Printf("failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, target string, err error) {
+	status := statusFromError(err)
+	message := http.StatusText(status)
+	if message == "" {
+		message = "unexpected error"
+	}
+	http.Error(w, message, status)
+
+	lg.ErrorLogger.Printf("request failed: target=%q status=%d error=%v", target, status, err)
+}
+
 func statusFromError(err error) int {
 	switch {
 	case err == nil:
@@ -2,22 +2,31 @@ package proxy
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 )
 
 type stubProcessor struct {
-	result     string
-	err        error
-	lastTarget string
+	result      string
+	insecure    int
+	diagnostics []Diagnostic
+	err         error
+	lastTarget  string
+	lastOpts    Options
 }
 
-func (s *stubProcessor) Process(ctx context.Context, targetURL string) (string, error) {
-	s.lastTarget = targetURL
-	return s.result, s.err
+func (s *stubProcessor) Process(ctx context.Context, targetURL string, opts Options) (Result, error) {
+	s.lastTarget, s.lastOpts = targetURL, opts
+	if s.err != nil {
+		return Result{Diagnostics: s.diagnostics}, s.err
+	}
+	return Result{Body: s.result, ContentType: opts.Format.contentType(), Insecure: s.insecure, Diagnostics: s.diagnostics}, nil
 }
 
 func TestHandlerSuccess(t *testing.T) {
@@ -102,3 +111,331 @@ func TestHandlerPreservesEscapedPath(t *testing.T) {
 		t.Fatalf("escaped path not preserved: want %q got %q", want, processor.lastTarget)
 	}
 }
+
+func TestHandlerFormatSelection(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		accept     string
+		wantTarget string
+		wantFormat Format
+		wantType   string
+	}{
+		{
+			name:       "default",
+			url:        "http://localhost:8000/https://example.com/sub?token=1",
+			wantTarget: "https://example.com/sub?token=1",
+			wantFormat: FormatBase64,
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name:       "accept header",
+			url:        "http://localhost:8000/https://example.com/sub?token=1",
+			accept:     "text/html, application/yaml;q=0.9",
+			wantTarget: "https://example.com/sub?token=1",
+			wantFormat: FormatClash,
+			wantType:   "application/yaml; charset=utf-8",
+		},
+		{
+			name:       "accept refuses q=0",
+			url:        "http://localhost:8000/https://example.com/sub?token=1",
+			accept:     "text/plain, application/yaml;q=0",
+			wantTarget: "https://example.com/sub?token=1",
+			wantFormat: FormatBase64,
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name:       "accept prefers highest q",
+			url:        "http://localhost:8000/https://example.com/sub?token=1",
+			accept:     "text/plain;q=0.5, application/yaml;q=0.8",
+			wantTarget: "https://example.com/sub?token=1",
+			wantFormat: FormatClash,
+			wantType:   "application/yaml; charset=utf-8",
+		},
+		{
+			name:       "generic json client",
+			url:        "http://localhost:8000/https://example.com/sub?token=1",
+			accept:     "application/json, text/plain, */*",
+			wantTarget: "https://example.com/sub?token=1",
+			wantFormat: FormatBase64,
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name:       "reserved option wins over accept",
+			url:        "http://localhost:8000/https://example.com/sub?a=1&__format=base64&b=%2F",
+			accept:     "application/yaml",
+			wantTarget: "https://example.com/sub?a=1&b=%2F",
+			wantFormat: FormatBase64,
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name:       "reserved option only",
+			url:        "http://localhost:8000/https://example.com/sub?__format=clash",
+			wantTarget: "https://example.com/sub",
+			wantFormat: FormatClash,
+			wantType:   "application/yaml; charset=utf-8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &stubProcessor{result: "payload"}
+			handler := NewHandler(processor)
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if processor.lastTarget != tt.wantTarget {
+				t.Fatalf("target: want %q got %q", tt.wantTarget, processor.lastTarget)
+			}
+			if processor.lastOpts.Format != tt.wantFormat {
+				t.Fatalf("format: want %q got %q", tt.wantFormat, processor.lastOpts.Format)
+			}
+			if got := rec.Result().Header.Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("content type: want %q got %q", tt.wantType, got)
+			}
+		})
+	}
+}
+
+func TestHandlerUnknownFormat(t *testing.T) {
+	processor := &stubProcessor{}
+	handler := NewHandler(processor)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__format=bogus", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", rec.Result().StatusCode)
+	}
+	if processor.lastTarget != "" {
+		t.Fatalf("processor should not be called, got target %q", processor.lastTarget)
+	}
+}
+
+func TestHandlerReservedOptions(t *testing.T) {
+	processor := &stubProcessor{}
+	handler := NewHandler(processor)
+
+	// Path segment and query options are merged; the upstream query, including
+	// its order and escaping, reaches the target untouched.
+	req := httptest.NewRequest(http.MethodGet,
+		"http://localhost:8000/__format=clash&pac-include=a.example/https://example.com/sub?b=2&__group=gfw&a=%2Fx&__pac-include=b.example", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Result().StatusCode)
+	}
+	if want := "https://example.com/sub?b=2&a=%2Fx"; processor.lastTarget != want {
+		t.Fatalf("target: want %q got %q", want, processor.lastTarget)
+	}
+	got := processor.lastOpts
+	if got.Format != FormatClash || got.Group != "gfw" {
+		t.Fatalf("unexpected options: %+v", got)
+	}
+	if len(got.PACInclude) != 2 || got.PACInclude[0] != "a.example" || got.PACInclude[1] != "b.example" {
+		t.Fatalf("unexpected pac-include: %v", got.PACInclude)
+	}
+}
+
+func TestHandlerUnknownOption(t *testing.T) {
+	processor := &stubProcessor{}
+	handler := NewHandler(processor)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8000/__fromat=clash/https://example.com", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", rec.Result().StatusCode)
+	}
+}
+
+func TestHandlerForwardsUnknownPrefixedQuery(t *testing.T) {
+	processor := &stubProcessor{}
+	handler := NewHandler(processor)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://e.example/sub?__cf_chl_tk=abc&__format=clash", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Result().StatusCode)
+	}
+	if want := "https://e.example/sub?__cf_chl_tk=abc"; processor.lastTarget != want {
+		t.Fatalf("target: want %q got %q", want, processor.lastTarget)
+	}
+	if processor.lastOpts.Format != FormatClash {
+		t.Fatalf("unexpected format: %s", processor.lastOpts.Format)
+	}
+}
+
+func TestHandlerTargetEncodings(t *testing.T) {
+	const want = "https://example.com/a%20b?x=1&y=2"
+	encoded := base64.RawURLEncoding.EncodeToString([]byte("https://example.com/a%20b?x=1"))
+
+	for name, reqURL := range map[string]string{
+		"path":             "http://localhost:8000/https://example.com/a%20b?x=1&y=2",
+		"collapsed scheme": "http://localhost:8000/https:/example.com/a%20b?x=1&y=2",
+		"url parameter":    "http://localhost:8000/?url=https%3A%2F%2Fexample.com%2Fa%2520b%3Fx%3D1&y=2",
+		"url parameter with options": "http://localhost:8000/__format=base64/?__group=g&url=" +
+			url.QueryEscape("https://example.com/a%20b?x=1&y=2"),
+		"base64url segment": "http://localhost:8000/" + encoded + "?y=2",
+		"padded base64":     "http://localhost:8000/" + base64.URLEncoding.EncodeToString([]byte("https://example.com/a%20b?x=1&y=2")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			processor := &stubProcessor{}
+			handler := NewHandler(processor)
+
+			req := httptest.NewRequest(http.MethodGet, reqURL, nil)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Result().StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status: %d", rec.Result().StatusCode)
+			}
+			if processor.lastTarget != want {
+				t.Fatalf("target: want %q got %q", want, processor.lastTarget)
+			}
+		})
+	}
+}
+
+func TestHandlerMultipleSources(t *testing.T) {
+	processor := &stubProcessor{}
+	handler := NewHandler(processor)
+
+	req := httptest.NewRequest(http.MethodGet,
+		"http://localhost:8000/?url=https%3A%2F%2Fa.example%2Fsub&url=https%3A%2F%2Fb.example%2Fsub&__merge=https%3A%2F%2Fc.example%2Fsub&__partial=serve", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if processor.lastTarget != "https://a.example/sub" {
+		t.Fatalf("unexpected target: %s", processor.lastTarget)
+	}
+	got := processor.lastOpts
+	if len(got.Merge) != 2 || got.Merge[0] != "https://b.example/sub" || got.Merge[1] != "https://c.example/sub" || !got.Partial {
+		t.Fatalf("unexpected options: %+v", got)
+	}
+}
+
+func TestHandlerClientToken(t *testing.T) {
+	processor := &stubProcessor{}
+	handler := NewHandler(processor)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__subset=3", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("subset without a client token: want 400 got %d", rec.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__subset=3", nil)
+	req.Header.Set("X-Client-Id", "alice")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Result().StatusCode)
+	}
+	if got := processor.lastOpts; got.Token != "alice" || got.Subset != 3 {
+		t.Fatalf("unexpected options: %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__sort=shuffle", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("shuffle without a client token: want 400 got %d", rec.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__sort=shuffle&__token=bob", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := processor.lastOpts; rec.Result().StatusCode != http.StatusOK || got.Token != "bob" || got.Sort != SortShuffle {
+		t.Fatalf("unexpected status %d or options %+v", rec.Result().StatusCode, got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__token=bob", nil)
+	req.Header.Set("X-Client-Id", "alice")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if processor.lastOpts.Token != "bob" {
+		t.Fatalf("token option must win over the header, got %q", processor.lastOpts.Token)
+	}
+}
+
+func TestHandlerInsecureHeader(t *testing.T) {
+	for _, insecure := range []int{0, 2} {
+		processor := &stubProcessor{result: "payload", insecure: insecure}
+		handler := NewHandler(processor)
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com?__insecure", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if !processor.lastOpts.Insecure {
+			t.Fatalf("insecure option not parsed")
+		}
+		want := ""
+		if insecure > 0 {
+			want = fmt.Sprint(insecure)
+		}
+		if got := rec.Result().Header.Get("X-Insecure-Proxies"); got != want {
+			t.Fatalf("X-Insecure-Proxies: want %q got %q", want, got)
+		}
+	}
+}
+
+func TestHandlerDiagnostics(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Index: 0, Name: "a", Field: "port", Reason: ReasonBadPort, Skipped: true},
+		{Index: 2, Name: "b", Field: "tls", Reason: ReasonInsecure, Skipped: true},
+		{Index: 3, Name: "c", Field: "tls", Reason: ReasonInsecure, Skipped: true},
+	}
+
+	processor := &stubProcessor{result: "encoded-result", diagnostics: diagnostics}
+	rec := httptest.NewRecorder()
+	NewHandler(processor).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com/sub", nil))
+	if got, want := rec.Result().Header.Get("X-Proxy-Diagnostics"), "bad-port=1, insecure=2"; got != want {
+		t.Fatalf("want diagnostics header %q, got %q", want, got)
+	}
+	if rec.Body.String() != "encoded-result" {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+
+	processor = &stubProcessor{diagnostics: diagnostics, err: fmt.Errorf("%w: none", ErrNoValidProxies)}
+	rec = httptest.NewRecorder()
+	NewHandler(processor).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com/sub", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("want status 204 without proxies, got %d", rec.Code)
+	}
+	if got, want := rec.Result().Header.Get("X-Proxy-Diagnostics"), "bad-port=1, insecure=2"; got != want {
+		t.Fatalf("want diagnostics header %q without proxies, got %q", want, got)
+	}
+
+	rec = httptest.NewRecorder()
+	NewHandler(processor).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8000/https://example.com/sub?__debug", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status 200 for a debug report, got %d", rec.Code)
+	}
+	var report debugReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("debug body is not JSON: %v: %s", err, rec.Body.String())
+	}
+	if report.Error == "" || report.Counts[ReasonInsecure] != 2 || len(report.Diagnostics) != 3 || report.Diagnostics[0].Field != "port" {
+		t.Fatalf("unexpected debug report: %+v", report)
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// normalizeTarget validates an upstream URL and returns its canonical form.
+func normalizeTarget(targetURL string) (string, error) {
+	targetURL = strings.TrimSpace(targetURL)
+	if targetURL == "" {
+		return "", fmt.Errorf("%w: empty target URL", ErrInvalidInput)
+	}
+	parsed, err := url.Parse(targetURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: target URL: %v", ErrInvalidInput, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("%w: unsupported scheme %q", ErrInvalidInput, parsed.Scheme)
+	}
+	return parsed.String(), nil
+}
+
+// collect resolves every source concurrently and merges their proxies in
+// source order, prefixing the names of source i with opts.Prefixes[i].
+// When opts.Group is set only the members of that group are taken from the
+// sources defining it. Unless opts.Partial is set, the first failing source
+// fails the whole request; with it, each failing source is reported in the
+// returned diagnostics. With opts.Strict a source with entries that
+// cannot be parsed fails as well; otherwise those entries are reported in
+// the returned diagnostics.
+func (s *Service) collect(ctx context.Context, sources []string, opts Options) ([]ProxyItem, []Diagnostic, error) {
+	subs, errs := make([]Subscription, len(sources)), make([]error, len(sources))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, source := range sources {
+		g.Go(func() error {
+			sub, err := s.resolve(gctx, source, 0)
+			if err == nil && opts.Strict {
+				if err = sub.Err(); err != nil {
+					err = fmt.Errorf("%w: %s: %v", ErrUpstream, source, err)
+				}
+			}
+			if err != nil && !opts.Partial {
+				return err
+			}
+			subs[i], errs[i] = sub, err
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		merged   []ProxyItem
+		diags    []Diagnostic
+		failed   int
+		groupErr error
+		found    = opts.Group == ""
+	)
+	for i, sub := range subs {
+		if errs[i] != nil {
+			diags = append(diags, sourceDiagnostic(sources[i], "", errs[i]))
+			failed++
+			continue
+		}
+		diags = append(diags, sub.allSkipped()...)
+		items := sub.allProxies()
+		if opts.Group != "" {
+			selected, err := sub.selectGroup(opts.Group)
+			if err != nil {
+				groupErr = err
+				continue
+			}
+			items, found = selected, true
+		}
+		if i < len(opts.Prefixes) {
+			items = prefixNames(items, opts.Prefixes[i])
+		}
+		merged = append(merged, items...)
+	}
+	if failed == len(sources) {
+		return nil, diags, errors.Join(errs...)
+	}
+	if !found {
+		return nil, diags, groupErr
+	}
+	return merged, diags, nil
+}
+
+// prefixNames returns a copy of items whose names start with prefix.
+func prefixNames(items []ProxyItem, prefix string) []ProxyItem {
+	if prefix == "" {
+		return items
+	}
+	result := make([]ProxyItem, len(items))
+	for i, it := range items {
+		it.Name = prefix + displayName(it)
+		result[i] = it
+	}
+	return result
+}
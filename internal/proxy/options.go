@@ -0,0 +1,351 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// optionPrefix marks the options addressed to this service rather than the
+// upstream. They can be given in two places, both stripped before the
+// target URL is built:
+//
+//	/https://example.com/sub?token=1&__format=clash&__group=gfw
+//	/__format=clash&group=gfw/https://example.com/sub?token=1
+//
+// In the path segment form only the segment itself carries the prefix.
+// In the query form only prefixed keys naming a known option are taken;
+// others, e.g. `__cf_chl_tk`, are left for the upstream.
+const optionPrefix = "__"
+
+// knownOptions lists every option name understood by parseOptions. Unknown
+// names in the options segment are rejected so that typos do not silently
+// fall back to defaults.
+var knownOptions = map[string]bool{
+	"format":        true,
+	"clash-group":   true,
+	"group":         true,
+	"pac-include":   true,
+	"pac-exclude":   true,
+	"include":       true,
+	"exclude":       true,
+	"rename":        true,
+	"name-template": true,
+	"merge":         true,
+	"prefix":        true,
+	"partial":       true,
+	"sort":          true,
+	"token":         true,
+	"subset":        true,
+	"insecure":      true,
+	"types":         true,
+	"strict":        true,
+	"debug":         true,
+}
+
+// clientIDHeader identifies the client when the request carries no token
+// option, e.g. when set by an authenticating reverse proxy.
+const clientIDHeader = "X-Client-Id"
+
+// Options carries the per-request settings understood by the Processor.
+type Options struct {
+	// Format selects the output encoding; the zero value means FormatBase64.
+	Format Format
+	// ClashGroup, when set, names a `select` group listing every proxy in
+	// the Clash output.
+	ClashGroup string
+	// Group limits the output to the members of this Clash proxy-group.
+	Group string
+	// PACInclude restricts the PAC output to these domains and their
+	// subdomains; empty means every host is proxied.
+	PACInclude []string
+	// PACExclude lists domains the PAC output always sends DIRECT.
+	PACExclude []string
+	// Include, when set, keeps only proxies whose name or server matches.
+	Include *regexp.Regexp
+	// Exclude drops proxies whose name or server matches.
+	Exclude *regexp.Regexp
+	// Rename rules are applied to every name in order. When empty the
+	// service defaults are used.
+	Rename []RenameRule
+	// NameTemplate, when set, produces the final name of every proxy after
+	// Rename. When nil the service default is used.
+	NameTemplate *template.Template
+	// Merge lists further upstream URLs whose proxies are appended to those
+	// of the target.
+	Merge []string
+	// Prefixes are prepended to the proxy names of the target (index 0) and
+	// of each Merge source, in order.
+	Prefixes []string
+	// Partial serves the sources that succeeded instead of failing the whole
+	// request when some of them cannot be fetched.
+	Partial bool
+	// Sort selects the output order; the zero value means SortUpstream.
+	Sort SortMode
+	// Token identifies the client, e.g. to seed SortShuffle. It defaults to
+	// the X-Client-Id request header.
+	Token string
+	// Subset, when positive, limits the output to this many proxies chosen
+	// per client by rendezvous hashing on Token.
+	Subset int
+	// Insecure includes plain HTTP proxies as http:// entries. The server
+	// Policy must allow it.
+	Insecure bool
+	// Types lists the proxy types to include besides TypeHTTP. The server
+	// Policy must allow each of them.
+	Types []string
+	// Strict fails a source when any of its entries cannot be parsed instead
+	// of skipping those entries.
+	Strict bool
+	// Debug serves a JSON report of the diagnostics instead of the
+	// subscription.
+	Debug bool
+}
+
+// collapsedScheme matches a target scheme whose `//` was collapsed or
+// duplicated by an intermediary, e.g. `https:/example.com`.
+var collapsedScheme = regexp.MustCompile(`^(?i)(https?):/*`)
+
+// parseRequest extracts the target URL and the service options from r. The
+// target keeps the client's escaping and query verbatim, minus the options.
+// It may be embedded in the path as-is or base64url encoded, or passed as
+// the `url` query parameter when the path is empty. Repeating `url` adds
+// the further values to Options.Merge.
+func parseRequest(r *http.Request) (string, Options, error) {
+	values := url.Values{}
+
+	// Trimming the leading slash yields the embedded target URL.
+	target := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if segment, rest, ok := strings.Cut(target, "/"); ok && strings.HasPrefix(segment, optionPrefix) {
+		parsed, err := url.ParseQuery(strings.TrimPrefix(segment, optionPrefix))
+		if err != nil {
+			return target, Options{}, fmt.Errorf("%w: options segment: %v", ErrInvalidInput, err)
+		}
+		values, target = parsed, rest
+	}
+
+	query, reserved, err := splitQuery(r.URL.RawQuery)
+	if err != nil {
+		return target, Options{}, err
+	}
+	if target == "" {
+		var merge []string
+		if target, merge, query, err = targetFromQuery(query); err != nil {
+			return target, Options{}, err
+		}
+		reserved["merge"] = append(merge, reserved["merge"]...)
+	} else if decoded, ok := decodeTargetSegment(target); ok {
+		target = decoded
+	}
+	target = joinQuery(collapsedScheme.ReplaceAllString(target, "$1://"), query)
+	for k, vs := range reserved {
+		values[k] = append(values[k], vs...)
+	}
+
+	opts, err := parseOptions(values, r.Header.Get("Accept"))
+	if err != nil {
+		return target, opts, err
+	}
+	if opts.Token == "" {
+		opts.Token = strings.TrimSpace(r.Header.Get(clientIDHeader))
+	}
+	if opts.Subset > 0 && opts.Token == "" {
+		return target, opts, fmt.Errorf("%w: option %ssubset requires %stoken or the %s header", ErrInvalidInput, optionPrefix, optionPrefix, clientIDHeader)
+	}
+	if opts.Sort == SortShuffle && opts.Token == "" {
+		return target, opts, fmt.Errorf("%w: option %ssort=%s requires %stoken or the %s header", ErrInvalidInput, optionPrefix, SortShuffle, optionPrefix, clientIDHeader)
+	}
+	return target, opts, nil
+}
+
+// targetFromQuery removes the `url` parameters from rawQuery. The first one
+// is returned as the target, the others as further sources, followed by the
+// remaining parameters.
+func targetFromQuery(rawQuery string) (string, []string, string, error) {
+	var urls, rest []string
+	for part := range strings.SplitSeq(rawQuery, "&") {
+		rawKey, rawValue, _ := strings.Cut(part, "=")
+		if rawKey != "url" {
+			if part != "" {
+				rest = append(rest, part)
+			}
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("%w: url parameter: %v", ErrInvalidInput, err)
+		}
+		urls = append(urls, value)
+	}
+	if len(urls) == 0 {
+		return "", nil, strings.Join(rest, "&"), nil
+	}
+	return urls[0], urls[1:], strings.Join(rest, "&"), nil
+}
+
+// decodeTargetSegment decodes a target given as a single base64url path
+// segment. Only results that look like an http(s) URL are accepted so that
+// ordinary paths are never misread.
+func decodeTargetSegment(segment string) (string, bool) {
+	if strings.ContainsAny(segment, ":/") {
+		return "", false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return "", false
+	}
+	lower := strings.ToLower(string(decoded))
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// joinQuery appends rawQuery to target, which may already carry a query.
+func joinQuery(target, rawQuery string) string {
+	switch {
+	case rawQuery == "":
+		return target
+	case strings.Contains(target, "?"):
+		return target + "&" + rawQuery
+	default:
+		return target + "?" + rawQuery
+	}
+}
+
+// splitQuery separates reserved options from a raw query string. The
+// remaining parameters, including prefixed keys that are not in
+// knownOptions, are returned verbatim so the upstream sees exactly what the
+// client sent; the reserved ones are returned without optionPrefix.
+func splitQuery(rawQuery string) (string, url.Values, error) {
+	var (
+		upstream []string
+		reserved = url.Values{}
+	)
+	for part := range strings.SplitSeq(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(part, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil || !strings.HasPrefix(key, optionPrefix) || !knownOptions[strings.TrimPrefix(key, optionPrefix)] {
+			upstream = append(upstream, part)
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: option %s: %v", ErrInvalidInput, key, err)
+		}
+		reserved.Add(strings.TrimPrefix(key, optionPrefix), value)
+	}
+	return strings.Join(upstream, "&"), reserved, nil
+}
+
+// parseOptions builds Options from the reserved values and the Accept
+// header. An explicit format option takes precedence over the header.
+func parseOptions(values url.Values, accept string) (Options, error) {
+	var opts Options
+	for k := range values {
+		if !knownOptions[k] {
+			return opts, fmt.Errorf("%w: unknown option %q", ErrInvalidInput, optionPrefix+k)
+		}
+	}
+
+	if values.Has("format") {
+		f, err := ParseFormat(values.Get("format"))
+		if err != nil {
+			return opts, err
+		}
+		opts.Format = f
+	} else if f, ok := formatFromAccept(accept); ok {
+		opts.Format = f
+	} else {
+		opts.Format = FormatBase64
+	}
+	opts.ClashGroup = strings.TrimSpace(values.Get("clash-group"))
+	opts.Group = strings.TrimSpace(values.Get("group"))
+	opts.PACInclude = listOption(values, "pac-include")
+	opts.PACExclude = listOption(values, "pac-exclude")
+
+	var err error
+	if opts.Include, err = regexpOption(values, "include"); err != nil {
+		return opts, err
+	}
+	if opts.Exclude, err = regexpOption(values, "exclude"); err != nil {
+		return opts, err
+	}
+	if opts.Rename, opts.NameTemplate, err = renameOptions(values); err != nil {
+		return opts, err
+	}
+
+	if opts.Sort, err = ParseSortMode(values.Get("sort")); err != nil {
+		return opts, err
+	}
+	opts.Token = values.Get("token")
+	if values.Has("subset") {
+		if opts.Subset, err = strconv.Atoi(values.Get("subset")); err != nil || opts.Subset <= 0 {
+			return opts, fmt.Errorf("%w: option %ssubset: want a positive integer, got %q", ErrInvalidInput, optionPrefix, values.Get("subset"))
+		}
+	}
+
+	if opts.Insecure, err = boolOption(values, "insecure"); err != nil {
+		return opts, err
+	}
+	if opts.Strict, err = boolOption(values, "strict"); err != nil {
+		return opts, err
+	}
+	if opts.Debug, err = boolOption(values, "debug"); err != nil {
+		return opts, err
+	}
+
+	for _, typ := range listOption(values, "types") {
+		opts.Types = append(opts.Types, strings.ToLower(typ))
+	}
+
+	opts.Merge = values["merge"]
+	opts.Prefixes = values["prefix"]
+	switch partial := values.Get("partial"); partial {
+	case "", "fail":
+	case "serve":
+		opts.Partial = true
+	default:
+		return opts, fmt.Errorf("%w: option %spartial: want fail or serve, got %q", ErrInvalidInput, optionPrefix, partial)
+	}
+	return opts, nil
+}
+
+// boolOption parses the named option with strconv.ParseBool. A present but
+// empty option counts as true.
+func boolOption(values url.Values, key string) (bool, error) {
+	if !values.Has(key) {
+		return false, nil
+	}
+	v := values.Get(key)
+	if v == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%w: option %s%s: %v", ErrInvalidInput, optionPrefix, key, err)
+	}
+	return b, nil
+}
+
+// listOption collects a list option given either as repeated parameters or
+// as a single comma-separated value.
+func listOption(values url.Values, key string) []string {
+	var result []string
+	for _, v := range values[key] {
+		for item := range strings.SplitSeq(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const pacTemplate = `var proxies = %s;
+var include = %s;
+var exclude = %s;
+
+function matchDomain(host, domains) {
+  for (var i = 0; i < domains.length; i++) {
+    var d = domains[i];
+    if (host === d || dnsDomainIs(host, "." + d)) {
+      return true;
+    }
+  }
+  return false;
+}
+
+function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  if (matchDomain(host, exclude)) {
+    return "DIRECT";
+  }
+  if (include.length > 0 && !matchDomain(host, include)) {
+    return "DIRECT";
+  }
+  return proxies;
+}
+`
+
+// encodePAC renders items as a Proxy Auto-Config script. Proxies are tried in
+// list order; hosts matching exclude, or not matching a non-empty include,
+// bypass them. Domains match themselves and all of their subdomains.
+func encodePAC(items []ProxyItem, include, exclude []string) (string, error) {
+	directives := make([]string, 0, len(items))
+	for _, it := range items {
+		keyword := "HTTPS "
+		if !it.TLS {
+			keyword = "PROXY "
+		}
+		directives = append(directives, keyword+net.JoinHostPort(it.Server, strconv.Itoa(it.Port)))
+	}
+
+	proxies, err := json.Marshal(strings.Join(directives, "; "))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal PAC proxies: %v", err)
+	}
+	includeJS, err := pacDomains(include)
+	if err != nil {
+		return "", err
+	}
+	excludeJS, err := pacDomains(exclude)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(pacTemplate, proxies, includeJS, excludeJS), nil
+}
+
+// pacDomains encodes a domain list as a JavaScript array literal.
+func pacDomains(domains []string) ([]byte, error) {
+	normalized := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		d = strings.TrimPrefix(d, "*.")
+		if d != "" {
+			normalized = append(normalized, d)
+		}
+	}
+	out, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PAC domains: %v", err)
+	}
+	return out, nil
+}
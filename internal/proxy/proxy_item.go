@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -26,55 +27,220 @@ type ProxyItem struct {
 	Type     string `yaml:"type"`
 	Name     string `yaml:"name"`
 	SNI      string `yaml:"sni"`
+
+	// SkipCertVerify disables verification of the proxy's TLS certificate.
+	SkipCertVerify bool `yaml:"skip-cert-verify"`
+	// Fingerprint pins the SHA-256 fingerprint of the proxy's certificate.
+	Fingerprint string `yaml:"fingerprint"`
+	// ALPN lists the protocols offered during the TLS handshake.
+	ALPN []string `yaml:"alpn"`
+	// ClientFingerprint names the uTLS ClientHello to mimic, e.g. chrome.
+	ClientFingerprint string `yaml:"client-fingerprint"`
+	// Headers are sent with every CONNECT request, e.g. an access token.
+	Headers map[string]string `yaml:"headers"`
+
+	// index is the position of the entry in its upstream document and
+	// source the URL of that document, reported in diagnostics.
+	index  int
+	source string
+}
+
+// Proxy types this service can serve. TypeHTTP2 marks an HTTPS proxy that
+// expects HTTP/2 CONNECT. Upstreams other than h2:// URIs have no such type;
+// see detectHTTP2.
+const (
+	TypeHTTP   = "http"
+	TypeSOCKS5 = "socks5"
+	TypeHTTP2  = "h2"
+)
+
+// Subscription is the parsed form of an upstream document.
+type Subscription struct {
+	Proxies   []ProxyItem
+	Providers []ProxyProvider
+	Groups    []ProxyGroup
+	// Skipped lists the entries left out because they could not be parsed.
+	Skipped []Diagnostic
+}
+
+// ProxyProvider is a Clash proxy-provider whose proxies live at a remote URL.
+type ProxyProvider struct {
+	Name string
+	URL  string
+	// Proxies and Skipped are filled in once the provider has been fetched.
+	Proxies []ProxyItem
+	Skipped []Diagnostic
+}
+
+// allProxies returns the inline proxies followed by those of every provider.
+func (sub Subscription) allProxies() []ProxyItem {
+	if len(sub.Providers) == 0 {
+		return sub.Proxies
+	}
+	result := slices.Clone(sub.Proxies)
+	for _, p := range sub.Providers {
+		result = append(result, p.Proxies...)
+	}
+	return result
+}
+
+// allSkipped returns the entries skipped in the document followed by those
+// skipped in every provider.
+func (sub Subscription) allSkipped() []Diagnostic {
+	if len(sub.Providers) == 0 {
+		return sub.Skipped
+	}
+	result := slices.Clone(sub.Skipped)
+	for _, p := range sub.Providers {
+		result = append(result, p.Skipped...)
+	}
+	return result
+}
+
+// Err returns the first entry skipped by the parser, in the document or in
+// one of its providers, or nil when every entry was parsed. Parsers are
+// lenient; checking Err restores strict parsing. Providers that could not be
+// fetched are tolerated, as in Clash itself.
+func (sub Subscription) Err() error {
+	for _, d := range sub.allSkipped() {
+		if d.Reason != ReasonSourceFailed {
+			return fmt.Errorf("fatal error while parsing proxy item: %w", d)
+		}
+	}
+	return nil
 }
 
 // ParseProxiesFromReader parses proxies from r, transforms each proxy using
-// transformProxy and returns the resulting https-lines.
-func ParseProxiesFromReader(r io.Reader) ([]string, int, error) {
-	var (
-		err  error
-		node ast.Node
-		path *goyaml.Path
-	)
-	if path, err = goyaml.PathString("$.proxies"); err != nil {
-		return nil, 0, fmt.Errorf("failed to create go-yaml.Path: %v", err)
+// transformProxy and returns the resulting https-lines. Entries that cannot
+// be parsed or served are skipped and reported in the diagnostics, duplicate
+// endpoints are dropped and repeated names made unique.
+func ParseProxiesFromReader(r io.Reader) ([]string, int, []Diagnostic, error) {
+	items, diags, err := ParseProxyItemsFromReader(r)
+	if err != nil {
+		return nil, 0, diags, err
+	}
+	items, invalid := validProxies(items, acceptance{})
+	items = dedupProxies(items)
+	uniqueNames(items)
+	diags = append(append(diags, invalid...), droppedFields(items, FormatBase64)...)
+
+	result, totalStrLen := make([]string, 0, len(items)), 0
+	for _, it := range items {
+		line := craftURL(it)
+		result = append(result, line)
+		totalStrLen += len(line)
+	}
+	totalStrLen += len(result)
+	return result, totalStrLen, diags, nil
+}
+
+// ParseProxyItemsFromReader parses every entry of $.proxies in r into a
+// ProxyItem. Entries are returned as-is; use validProxies to drop the ones
+// that cannot be served. Entries that cannot be parsed are skipped and
+// reported in the diagnostics.
+func ParseProxyItemsFromReader(r io.Reader) ([]ProxyItem, []Diagnostic, error) {
+	sub, err := ParseClashFromReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sub.Proxies, sub.Skipped, nil
+}
+
+// ParseClashFromReader parses the inline proxies, the remote proxy-providers
+// and the proxy-groups of a Clash document. At least one of proxies or
+// proxy-providers must be present. Proxies that cannot be parsed are
+// recorded in Skipped; see Subscription.Err.
+func ParseClashFromReader(r io.Reader) (Subscription, error) {
+	var sub Subscription
+	f, err := readYAMLFile(r)
+	if err != nil {
+		return sub, err
+	}
+
+	node, proxiesErr := lookupNode(f, "proxies")
+	if proxiesErr != nil && !errors.Is(proxiesErr, goyaml.ErrNotFoundNode) {
+		return sub, fmt.Errorf("failed to read proxies: %v", proxiesErr)
+	}
+	if sub.Providers, err = parseProviders(f); err != nil {
+		return sub, err
+	}
+	if sub.Groups, err = parseGroups(f); err != nil {
+		return sub, err
 	}
-	if node, err = path.ReadNode(io.LimitReader(r, maxYAMLBytes)); err != nil {
-		if errors.Is(err, io.EOF) {
-			return nil, 0, fmt.Errorf("upstream empty")
+	if node == nil {
+		if len(sub.Providers) == 0 {
+			return sub, fmt.Errorf("failed to read proxies: %v", proxiesErr)
 		}
-		return nil, 0, fmt.Errorf("failed to read proxies: %v", err)
+		return sub, nil
 	}
 
 	seq, ok := node.(ast.ArrayNode)
 	if !ok {
-		return nil, 0, fmt.Errorf("proxies must be a sequence, got %T", node)
+		return sub, fmt.Errorf("proxies must be a sequence, got %T", node)
 	}
 
-	result, totalStrLen := make([]string, 0, 64), 0
-	for iter := seq.ArrayRange(); iter.Next(); {
+	sub.Proxies = make([]ProxyItem, 0, 64)
+	for i, iter := 0, seq.ArrayRange(); iter.Next(); i++ {
 		elem := iter.Value()
 		mnode, ok := elem.(*ast.MappingNode)
 		if !ok {
-			return nil, 0, fmt.Errorf("proxy entry not a mapping, got %T", elem)
+			sub.Skipped = append(sub.Skipped, entryDiagnostic(i, "", fmt.Errorf("proxy entry not a mapping, got %T", elem)))
+			continue
 		}
 
-		line, err := transformProxy(mnode)
+		it, err := transformProxy(mnode)
 		if err != nil {
-			return nil, 0, fmt.Errorf("fatal error while parsing proxy item: %v", err)
+			sub.Skipped = append(sub.Skipped, entryDiagnostic(i, it.Name, err))
+			continue
+		}
+		it.index = i
+		sub.Proxies = append(sub.Proxies, it)
+	}
+	return sub, nil
+}
+
+// parseProviders extracts the remote entries of $.proxy-providers. Local
+// providers (type: file) cannot be resolved by this service and are skipped.
+func parseProviders(f *ast.File) ([]ProxyProvider, error) {
+	node, err := lookupNode(f, "proxy-providers")
+	if errors.Is(err, goyaml.ErrNotFoundNode) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy-providers: %v", err)
+	}
+	mnode, ok := node.(*ast.MappingNode)
+	if !ok {
+		return nil, fmt.Errorf("proxy-providers must be a mapping, got %T", node)
+	}
+
+	var result []ProxyProvider
+	for miter := mnode.MapRange(); miter.Next(); {
+		name := strings.TrimSpace(miter.Key().String())
+		pnode, ok := miter.Value().(*ast.MappingNode)
+		if !ok {
+			return nil, fmt.Errorf("proxy provider %s not a mapping, got %T", name, miter.Value())
+		}
+		p := ProxyProvider{Name: name}
+		for piter := pnode.MapRange(); piter.Next(); {
+			if strings.TrimSpace(piter.Key().String()) != "url" {
+				continue
+			}
+			if p.URL, err = nodeToString(piter.Value()); err != nil {
+				return nil, fmt.Errorf("failed while parsing the url of proxy provider %s: %v", name, err)
+			}
 		}
-		if line == "" {
+		if p.URL == "" {
+			lg.WarnLogger.Printf("skipped proxy provider %s without url", name)
 			continue
 		}
-		result = append(result, line)
-		totalStrLen += len(line)
+		result = append(result, p)
 	}
-	totalStrLen += len(result)
-	return result, totalStrLen, nil
+	return result, nil
 }
 
-// transformProxy converts a parsed ProxyItem into the https://... form
-func transformProxy(mnode *ast.MappingNode) (string, error) {
+// transformProxy converts a YAML mapping node into a ProxyItem.
+func transformProxy(mnode *ast.MappingNode) (ProxyItem, error) {
 	var it ProxyItem
 	for miter := mnode.MapRange(); miter.Next(); {
 		k, v := strings.TrimSpace(miter.Key().String()), miter.Value()
@@ -83,94 +249,212 @@ func transformProxy(mnode *ast.MappingNode) (string, error) {
 			if s, err := nodeToString(v); err == nil {
 				it.Username = s
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "password":
 			if s, err := nodeToString(v); err == nil {
 				it.Password = s
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "server":
 			if s, err := nodeToString(v); err == nil {
 				it.Server = s
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "port":
 			if p, err := nodeToInt(v); err == nil {
 				it.Port = p
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "tls":
 			if b, err := nodeToBool(v); err == nil {
 				it.TLS = b
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "type":
 			if s, err := nodeToString(v); err == nil {
 				it.Type = s
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "name":
 			if s, err := nodeToString(v); err == nil {
 				it.Name = s
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
 			}
 		case "sni":
 			if s, err := nodeToString(v); err == nil {
 				it.SNI = s
 			} else {
-				return "", fmt.Errorf("failed while parsing the key %s: %v", k, err)
+				return it, keyError(k, err)
+			}
+		case "skip-cert-verify":
+			if b, err := nodeToBool(v); err == nil {
+				it.SkipCertVerify = b
+			} else {
+				return it, keyError(k, err)
+			}
+		case "fingerprint":
+			if s, err := nodeToString(v); err == nil {
+				it.Fingerprint = s
+			} else {
+				return it, keyError(k, err)
+			}
+		case "alpn":
+			if l, err := nodeToStringList(v); err == nil {
+				it.ALPN = l
+			} else {
+				return it, keyError(k, err)
+			}
+		case "client-fingerprint":
+			if s, err := nodeToString(v); err == nil {
+				it.ClientFingerprint = s
+			} else {
+				return it, keyError(k, err)
+			}
+		case "headers":
+			if m, err := nodeToStringMap(v); err == nil {
+				it.Headers = m
+			} else {
+				return it, keyError(k, err)
 			}
 		default:
 			// ignore unknown keys
 		}
 	}
 
-	return craftURL(it), nil
+	return it, nil
 }
 
-func craftURL(it ProxyItem) string {
-	if it.Username == "" {
-		lg.WarnLogger.Printf("username is empty in proxy item: %v", it)
-		return ""
-	}
-	if it.Password == "" {
-		lg.WarnLogger.Printf("password is empty in proxy item: %v", it)
-		return ""
+// detectHTTP2 retypes the HTTPS proxies of items whose alpn offers nothing
+// but h2 as TypeHTTP2, for requests that ask for h2 proxies in a format
+// that can express them. Neither mihomo nor sing-box has a type of its own
+// for these, so every other request serves them as plain HTTPS proxies.
+func detectHTTP2(items []ProxyItem, f Format) []ProxyItem {
+	if !f.supports(ProxyItem{Type: TypeHTTP2, TLS: true}) {
+		return items
 	}
-	if it.Server == "" {
-		lg.WarnLogger.Printf("server addr is empty in proxy item: %v", it)
-		return ""
+	result := make([]ProxyItem, 0, len(items))
+	for _, it := range items {
+		if it.Type == TypeHTTP && it.TLS && slices.Equal(it.ALPN, []string{"h2"}) {
+			it.Type = TypeHTTP2
+		}
+		result = append(result, it)
 	}
-	if it.Port <= 0 || it.Port > 65535 {
-		lg.WarnLogger.Printf("invalid port %d in proxy item: %v", it.Port, it)
-		return ""
+	return result
+}
+
+// acceptance lists the proxies validateProxy lets through besides
+// authenticated HTTPS ones. The zero value accepts nothing else.
+type acceptance struct {
+	// insecure accepts plain HTTP proxies.
+	insecure bool
+	// anonymous accepts proxies without username and password.
+	anonymous bool
+	// types lists the proxy types accepted besides TypeHTTP.
+	types []string
+}
+
+// validProxies returns the items that can be served under acc, along with
+// a diagnostic for every item it drops.
+func validProxies(items []ProxyItem, acc acceptance) ([]ProxyItem, []Diagnostic) {
+	result, diags := make([]ProxyItem, 0, len(items)), []Diagnostic(nil)
+	for _, it := range items {
+		if d, ok := validateProxy(it, acc); ok {
+			result = append(result, it)
+		} else {
+			diags = append(diags, d)
+		}
 	}
-	if !it.TLS {
-		lg.WarnLogger.Printf("skipped insecure HTTP proxy: %v", it)
-		return ""
+	return result, diags
+}
+
+// validateProxy reports whether it can be served under acc, and why not.
+func validateProxy(it ProxyItem, acc acceptance) (Diagnostic, bool) {
+	switch {
+	case it.Type != TypeHTTP && !slices.Contains(acc.types, it.Type):
+		return proxyDiagnostic(it, ReasonUnsupportedType, "type", fmt.Sprintf("proxy type %s is not requested", it.Type), true), false
+	case it.Username == "" && it.Password == "" && !acc.anonymous:
+		return proxyDiagnostic(it, ReasonMissingCredentials, "username", "credentials are empty", true), false
+	case it.Username == "" && it.Password != "":
+		return proxyDiagnostic(it, ReasonMissingUsername, "username", "username is empty", true), false
+	case it.Password == "" && it.Username != "":
+		return proxyDiagnostic(it, ReasonMissingPassword, "password", "password is empty", true), false
+	case it.Server == "":
+		return proxyDiagnostic(it, ReasonMissingServer, "server", "server addr is empty", true), false
+	case it.Port <= 0 || it.Port > 65535:
+		return proxyDiagnostic(it, ReasonBadPort, "port", fmt.Sprintf("invalid port %d", it.Port), true), false
+	case !it.TLS && !acc.insecure:
+		return proxyDiagnostic(it, ReasonInsecure, "tls", "plain HTTP proxies are not requested", true), false
 	}
-	if it.Type != "http" {
-		lg.WarnLogger.Printf("unsupported proxy type %s in proxy item: %v", it.Type, it)
-		return ""
+	return Diagnostic{}, true
+}
+
+// proxyScheme ties a URI scheme to the proxy it describes, along with the
+// port assumed when a URI has none.
+type proxyScheme struct {
+	scheme string
+	typ    string
+	tls    bool
+	port   int
+}
+
+// uriSchemes lists the schemes craftURL emits and parseProxyURI reads.
+var uriSchemes = []proxyScheme{
+	{scheme: "https", typ: TypeHTTP, tls: true, port: 443},
+	{scheme: "http", typ: TypeHTTP, tls: false, port: 80},
+	{scheme: "socks5+tls", typ: TypeSOCKS5, tls: true, port: 1080},
+	{scheme: "socks5", typ: TypeSOCKS5, tls: false, port: 1080},
+	{scheme: "h2", typ: TypeHTTP2, tls: true, port: 443},
+}
+
+// uriScheme returns the URI scheme of it, or "" when it has none.
+func uriScheme(it ProxyItem) string {
+	for _, s := range uriSchemes {
+		if s.typ == it.Type && s.tls == it.TLS {
+			return s.scheme
+		}
 	}
+	return ""
+}
 
+// craftURL converts a validated ProxyItem into the https://... form, or the
+// matching form from uriSchemes for other types. TLS settings are carried
+// as query parameters: sni, insecure=1, fingerprint, alpn (comma-separated)
+// and fp for the client fingerprint. Headers cannot be expressed; see
+// droppedFields.
+func craftURL(it ProxyItem) string {
 	u, q := &url.URL{
-		Scheme: "https",
+		Scheme: uriScheme(it),
 		Host:   net.JoinHostPort(it.Server, strconv.Itoa(it.Port)),
-		User:   url.UserPassword(it.Username, it.Password),
 	}, url.Values{}
+	if it.Username != "" {
+		u.User = url.UserPassword(it.Username, it.Password)
+	}
 	if it.Name != "" {
 		u.Fragment = it.Name
 	}
-	if it.SNI != "" {
-		q.Set("sni", it.SNI)
+	if it.TLS {
+		if it.SNI != "" {
+			q.Set("sni", it.SNI)
+		}
+		if it.SkipCertVerify {
+			q.Set("insecure", "1")
+		}
+		if it.Fingerprint != "" {
+			q.Set("fingerprint", it.Fingerprint)
+		}
+		if len(it.ALPN) > 0 {
+			q.Set("alpn", strings.Join(it.ALPN, ","))
+		}
+		if it.ClientFingerprint != "" {
+			q.Set("fp", it.ClientFingerprint)
+		}
 		u.RawQuery = q.Encode()
 	}
 	return u.String()
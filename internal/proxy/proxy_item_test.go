@@ -18,7 +18,7 @@ func TestParseProxiesFromReader_Success(t *testing.T) {
   username: admin
 `
 
-	proxies, _, err := ParseProxiesFromReader(io.NopCloser(strings.NewReader(body)))
+	proxies, _, _, err := ParseProxiesFromReader(io.NopCloser(strings.NewReader(body)))
 	if err != nil {
 		t.Fatalf("ParseProxiesFromReader returned error: %v", err)
 	}
@@ -38,7 +38,166 @@ func TestParseProxiesFromReader_TooLarge(t *testing.T) {
 	defer func() { maxYAMLBytes = old }()
 
 	body := "proxies:\n- name: \"S\"\n  password: x\n"
-	if _, _, err := ParseProxiesFromReader(strings.NewReader(body)); err == nil {
+	if _, _, _, err := ParseProxiesFromReader(strings.NewReader(body)); err == nil {
 		t.Fatalf("expected error for severely truncated upstream, got nil")
 	}
 }
+
+func TestParseProxiesFromReader_Dedup(t *testing.T) {
+	body := `proxies:
+- {name: "HK", type: http, server: a.example, port: 443, username: u, password: p, tls: true}
+- {name: "HK Premium", type: http, server: a.example, port: 443, username: u, password: p, tls: true}
+- {name: "HK", type: http, server: b.example, port: 443, username: u, password: p, tls: true}
+`
+
+	proxies, _, _, err := ParseProxiesFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseProxiesFromReader returned error: %v", err)
+	}
+	want := []string{"https://u:p@a.example:443#HK", "https://u:p@b.example:443#HK%202"}
+	if len(proxies) != len(want) {
+		t.Fatalf("want %d proxies, got %d: %v", len(want), len(proxies), proxies)
+	}
+	for i := range want {
+		if proxies[i] != want[i] {
+			t.Fatalf("proxy %d: want %s got %s", i, want[i], proxies[i])
+		}
+	}
+}
+
+func TestValidateProxyCredentials(t *testing.T) {
+	base := ProxyItem{Server: "a.example", Port: 443, TLS: true, Type: "http"}
+	withCreds := func(user, pass string) ProxyItem {
+		it := base
+		it.Username, it.Password = user, pass
+		return it
+	}
+
+	tests := []struct {
+		name   string
+		item   ProxyItem
+		acc    acceptance
+		want   bool
+		reason Reason
+	}{
+		{name: "authenticated", item: withCreds("u", "p"), want: true},
+		{name: "anonymous rejected by default", item: withCreds("", ""), reason: ReasonMissingCredentials},
+		{name: "anonymous allowed", item: withCreds("", ""), acc: acceptance{anonymous: true}, want: true},
+		{name: "username only", item: withCreds("u", ""), acc: acceptance{anonymous: true}, reason: ReasonMissingPassword},
+		{name: "password only", item: withCreds("", "p"), acc: acceptance{anonymous: true}, reason: ReasonMissingUsername},
+		{name: "share link", item: ProxyItem{Type: "vmess"}, reason: ReasonUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, got := validateProxy(tt.item, tt.acc)
+			if got != tt.want || d.Reason != tt.reason {
+				t.Fatalf("want %v (%q) got %v (%q)", tt.want, tt.reason, got, d.Reason)
+			}
+		})
+	}
+
+	if got := craftURL(withCreds("", "")); got != "https://a.example:443" {
+		t.Fatalf("unexpected anonymous URI: %s", got)
+	}
+}
+
+func TestParseProxiesFromReader_TLSOptions(t *testing.T) {
+	body := `proxies:
+- name: "HK"
+  type: http
+  server: a.example
+  port: 443
+  username: u
+  password: p
+  tls: true
+  sni: sni.example
+  skip-cert-verify: true
+  fingerprint: ab:cd
+  alpn: [h2, http/1.1]
+  client-fingerprint: chrome
+`
+
+	proxies, _, _, err := ParseProxiesFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseProxiesFromReader returned error: %v", err)
+	}
+	want := "https://u:p@a.example:443?alpn=h2%2Chttp%2F1.1&fingerprint=ab%3Acd&fp=chrome&insecure=1&sni=sni.example#HK"
+	if len(proxies) != 1 || proxies[0] != want {
+		t.Fatalf("want [%s], got %v", want, proxies)
+	}
+
+	it, err := parseProxyURI(want)
+	if err != nil {
+		t.Fatalf("parseProxyURI returned error: %v", err)
+	}
+	if !it.SkipCertVerify || it.Fingerprint != "ab:cd" || it.ClientFingerprint != "chrome" || strings.Join(it.ALPN, ",") != "h2,http/1.1" {
+		t.Fatalf("round trip lost TLS options: %+v", it)
+	}
+}
+
+func TestParseClashFromReader_Lenient(t *testing.T) {
+	body := `proxies:
+- {name: "bad port", type: http, server: a.example, port: abc, username: u, password: p, tls: true}
+- just a string
+- {name: "ok", type: http, server: b.example, port: 443, username: u, password: p, tls: true}
+`
+
+	sub, err := ParseClashFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseClashFromReader returned error: %v", err)
+	}
+	if len(sub.Proxies) != 1 || sub.Proxies[0].Name != "ok" {
+		t.Fatalf("want only the valid entry, got %+v", sub.Proxies)
+	}
+	want := []Diagnostic{
+		{Index: 0, Name: "bad port", Field: "port", Reason: ReasonBadPort, Skipped: true},
+		{Index: 1, Reason: ReasonMalformed, Skipped: true},
+	}
+	if len(sub.Skipped) != len(want) {
+		t.Fatalf("want %d entries skipped, got %v", len(want), sub.Skipped)
+	}
+	for i, d := range sub.Skipped {
+		d.Detail = ""
+		if d != want[i] {
+			t.Fatalf("diagnostic %d: want %+v got %+v", i, want[i], d)
+		}
+	}
+	if err := sub.Err(); err == nil || !strings.Contains(err.Error(), "entry 0") {
+		t.Fatalf("want strict error naming entry 0, got %v", err)
+	}
+
+	proxies, _, diags, err := ParseProxiesFromReader(strings.NewReader(body))
+	if err != nil || len(proxies) != 1 || len(diags) != 2 {
+		t.Fatalf("want the valid proxy served and 2 diagnostics, got %v, %v, %v", proxies, diags, err)
+	}
+}
+
+func TestDetectHTTP2(t *testing.T) {
+	body := `proxies:
+- {name: h2, type: http, server: a.example, port: 443, username: u, password: p, tls: true, alpn: [h2]}
+- {name: both, type: http, server: b.example, port: 443, username: u, password: p, tls: true, alpn: [h2, http/1.1]}
+- {name: plain, type: http, server: c.example, port: 80, username: u, password: p, alpn: [h2]}
+`
+
+	sub, err := ParseClashFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseClashFromReader returned error: %v", err)
+	}
+	for _, it := range sub.Proxies {
+		if it.Type != TypeHTTP {
+			t.Fatalf("proxy %s: parsers must keep type %s, got %s", it.Name, TypeHTTP, it.Type)
+		}
+	}
+
+	want := []string{TypeHTTP2, TypeHTTP, TypeHTTP}
+	for i, it := range detectHTTP2(sub.Proxies, FormatBase64) {
+		if it.Type != want[i] {
+			t.Fatalf("proxy %s: want type %s got %s", it.Name, want[i], it.Type)
+		}
+	}
+	for _, it := range detectHTTP2(sub.Proxies, FormatSurge) {
+		if it.Type != TypeHTTP {
+			t.Fatalf("proxy %s: surge cannot express h2, want type %s got %s", it.Name, TypeHTTP, it.Type)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// encodeQuanX renders items as Quantumult X `server_remote` lines. Like
+// Surge and Loon, Quantumult X splits parameters at commas, so credentials
+// are quoted where needed.
+func encodeQuanX(items []ProxyItem) string {
+	var sb strings.Builder
+	for _, it := range items {
+		kind := "http="
+		if it.Type == TypeSOCKS5 {
+			kind = "socks5="
+		}
+		params := []string{kind + net.JoinHostPort(it.Server, strconv.Itoa(it.Port))}
+		if it.Username != "" {
+			params = append(params, "username="+policyQuote(it.Username), "password="+policyQuote(it.Password))
+		}
+		params = append(params, "over-tls="+strconv.FormatBool(it.TLS))
+		if it.TLS && it.SNI != "" {
+			params = append(params, "tls-host="+it.SNI)
+		}
+		if it.TLS && it.SkipCertVerify {
+			params = append(params, "tls-verification=false")
+		}
+		if it.TLS && it.Fingerprint != "" {
+			params = append(params, "tls-cert-sha256="+it.Fingerprint)
+		}
+		params = append(params, "tag="+policyName(it))
+		sb.WriteString(strings.Join(params, ", "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
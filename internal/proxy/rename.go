@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// RenameRule rewrites the part of a proxy name matching Pattern with
+// Replacement, which may reference capture groups like
+// regexp.Regexp.ReplaceAllString does.
+type RenameRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRenameRule parses a rule written as `pattern@replacement`. The last
+// `@` separates the two so that patterns may contain it.
+func ParseRenameRule(s string) (RenameRule, error) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return RenameRule{}, fmt.Errorf("%w: rename rule %q: missing @", ErrInvalidInput, s)
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return RenameRule{}, fmt.Errorf("%w: rename rule %q: %v", ErrInvalidInput, s, err)
+	}
+	return RenameRule{Pattern: re, Replacement: s[i+1:]}, nil
+}
+
+// nameData is the value a name template is executed with. Index counts the
+// emitted proxies starting at 1.
+type nameData struct {
+	ProxyItem
+	Index int
+}
+
+// ParseNameTemplate parses a text/template producing proxy names, e.g.
+// `{{.Index}}-{{.Server}}`. All ProxyItem fields and Index are available.
+func ParseNameTemplate(s string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: name template: %v", ErrInvalidInput, err)
+	}
+	return tmpl, nil
+}
+
+// renameProxies applies rules in order to every name, then tmpl when set.
+// items is not modified.
+func renameProxies(items []ProxyItem, rules []RenameRule, tmpl *template.Template) ([]ProxyItem, error) {
+	if len(rules) == 0 && tmpl == nil {
+		return items, nil
+	}
+
+	result := slices.Clone(items)
+	var sb strings.Builder
+	for i := range result {
+		it := &result[i]
+		for _, rule := range rules {
+			it.Name = rule.Pattern.ReplaceAllString(it.Name, rule.Replacement)
+		}
+		if tmpl == nil {
+			continue
+		}
+		sb.Reset()
+		if err := tmpl.Execute(&sb, nameData{ProxyItem: *it, Index: i + 1}); err != nil {
+			return nil, fmt.Errorf("%w: name template: %v", ErrInvalidInput, err)
+		}
+		it.Name = sb.String()
+	}
+	return result, nil
+}
+
+// renameOptions parses the rename rules and the name template of a request.
+func renameOptions(values url.Values) ([]RenameRule, *template.Template, error) {
+	var rules []RenameRule
+	for _, v := range values["rename"] {
+		rule, err := ParseRenameRule(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if !values.Has("name-template") {
+		return rules, nil, nil
+	}
+	tmpl, err := ParseNameTemplate(values.Get("name-template"))
+	return rules, tmpl, err
+}
@@ -4,89 +4,270 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"strings"
+	"slices"
+	"text/template"
 
+	lg "dummy-https-proxy-sub/internal/logger"
+
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/singleflight"
 )
 
+// maxProviderDepth bounds how many levels of nested proxy-providers are
+// followed from the requested subscription.
+const maxProviderDepth = 3
+
 // HTTPClient is the minimal subset of an http client we require.
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
 // Service coordinates fetching upstream YAML and converting proxies into
-// https://... lines which are then base64-encoded.
+// the subscription format requested by the client.
 type Service struct {
 	client HTTPClient
 	group  singleflight.Group
+
+	rename       []RenameRule
+	nameTemplate *template.Template
+	policy       Policy
+}
+
+// Policy holds the server-side switches deciding which proxies requests may
+// ask for beyond authenticated HTTPS ones. The zero value is the most
+// restrictive.
+type Policy struct {
+	// AllowInsecure lets requests include plain HTTP proxies with the
+	// insecure option.
+	AllowInsecure bool
+	// AllowAnonymous serves proxies that have neither username nor
+	// password, e.g. IP-allowlisted ones. Proxies with only one of them are
+	// always rejected.
+	AllowAnonymous bool
+	// AllowTypes lists the proxy types besides TypeHTTP, such as
+	// TypeSOCKS5 or TypeHTTP2, that requests may include with the types
+	// option.
+	AllowTypes []string
+}
+
+// WithPolicy sets the server-side Policy.
+func WithPolicy(p Policy) ServiceOption {
+	return func(s *Service) {
+		s.policy = p
+	}
+}
+
+// ServiceOption configures server-side defaults of a Service.
+type ServiceOption func(*Service)
+
+// WithRename sets the rename rules and name template used for requests that
+// do not specify their own.
+func WithRename(rules []RenameRule, tmpl *template.Template) ServiceOption {
+	return func(s *Service) {
+		s.rename, s.nameTemplate = rules, tmpl
+	}
 }
 
 // NewService constructs a Service.
-func NewService(client HTTPClient) *Service {
-	return &Service{client: client}
+func NewService(client HTTPClient, opts ...ServiceOption) *Service {
+	s := &Service{client: client}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Result is the rendered subscription handed back to the HTTP handler.
+type Result struct {
+	Body        string
+	ContentType string
+	// Proxies counts the proxies included in Body.
+	Proxies int
+	// Insecure counts the plain HTTP proxies included in Body.
+	Insecure int
+	// Diagnostics lists the upstream entries that were skipped or served
+	// with a caveat. It is set even when Process fails with
+	// ErrNoValidProxies.
+	Diagnostics []Diagnostic
 }
 
-// Process fetches the YAML at targetURL and returns a single-line base64
-// encoding of the newline-separated https proxy addresses.
-func (s *Service) Process(ctx context.Context, targetURL string) (string, error) {
+// Process fetches the subscription at targetURL, merged with the ones listed
+// in opts.Merge, and renders the valid https proxies they list in the format
+// selected by opts.
+func (s *Service) Process(ctx context.Context, targetURL string, opts Options) (Result, error) {
 	if s == nil {
-		return "", fmt.Errorf("%w: service not initialized", ErrInvalidInput)
+		return Result{}, fmt.Errorf("%w: service not initialized", ErrInvalidInput)
 	}
 	if s.client == nil {
-		return "", fmt.Errorf("%w: HTTPClient not initialized", ErrInvalidInput)
+		return Result{}, fmt.Errorf("%w: HTTPClient not initialized", ErrInvalidInput)
+	}
+	if opts.Insecure && !s.policy.AllowInsecure {
+		return Result{}, fmt.Errorf("%w: plain HTTP proxies are disabled on this server", ErrInvalidInput)
+	}
+	for _, typ := range opts.Types {
+		if typ != TypeHTTP && !slices.Contains(s.policy.AllowTypes, typ) {
+			return Result{}, fmt.Errorf("%w: %s proxies are disabled on this server", ErrInvalidInput, typ)
+		}
 	}
+	acc := acceptance{insecure: opts.Insecure, anonymous: s.policy.AllowAnonymous, types: opts.Types}
 
-	targetURL = strings.TrimSpace(targetURL)
-	if targetURL == "" {
-		return "", fmt.Errorf("%w: empty target URL", ErrInvalidInput)
+	sources := make([]string, 0, 1+len(opts.Merge))
+	for _, source := range append([]string{targetURL}, opts.Merge...) {
+		normalized, err := normalizeTarget(source)
+		if err != nil {
+			return Result{}, err
+		}
+		sources = append(sources, normalized)
 	}
-	parsed, err := url.Parse(targetURL)
+
+	items, diags, err := s.collect(ctx, sources, opts)
 	if err != nil {
-		return "", fmt.Errorf("%w: target URL: %v", ErrInvalidInput, err)
+		return Result{}, err
 	}
-	if parsed.Scheme != "http" && parsed.Scheme != "https" {
-		return "", fmt.Errorf("%w: unsupported scheme %q", ErrInvalidInput, parsed.Scheme)
+	if slices.Contains(opts.Types, TypeHTTP2) {
+		items = detectHTTP2(items, opts.Format)
 	}
+	items, invalid := validProxies(filterProxies(items, opts.Include, opts.Exclude), acc)
+	items, inexpressible := expressibleProxies(items, opts.Format)
+	items = dedupProxies(items)
+	diags = append(append(diags, invalid...), inexpressible...)
+	if len(items) == 0 {
+		logDiagnostics(diags)
+		return Result{Diagnostics: diags}, fmt.Errorf("%w: no valid proxies found", ErrNoValidProxies)
+	}
+
+	items = shardProxies(items, opts.Subset, opts.Token)
+	sortProxies(items, opts.Sort, opts.Token)
 
-	targetURL = parsed.String()
+	rules, tmpl := opts.Rename, opts.NameTemplate
+	if len(rules) == 0 {
+		rules = s.rename
+	}
+	if tmpl == nil {
+		tmpl = s.nameTemplate
+	}
+	if items, err = renameProxies(items, rules, tmpl); err != nil {
+		return Result{}, err
+	}
+	uniqueNames(items)
+	diags = append(diags, droppedFields(items, opts.Format)...)
+	logDiagnostics(diags)
+
+	body, err := render(items, opts)
+	if err != nil {
+		return Result{}, fmt.Errorf("render %s output: %v", opts.Format, err)
+	}
+	result := Result{Body: body, ContentType: opts.Format.contentType(), Proxies: len(items), Diagnostics: diags}
+	for _, it := range items {
+		if !it.TLS {
+			result.Insecure++
+		}
+	}
+	return result, nil
+}
+
+// resolve fetches the subscription at targetURL together with its
+// proxy-providers. Providers are fetched concurrently and may themselves
+// declare providers, up to maxProviderDepth levels. A provider that cannot
+// be fetched is left empty with a ReasonSourceFailed diagnostic, mirroring
+// Clash itself.
+func (s *Service) resolve(ctx context.Context, targetURL string, depth int) (Subscription, error) {
+	sub, err := s.fetch(ctx, targetURL)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if len(sub.Providers) == 0 {
+		return sub, nil
+	}
+	if depth >= maxProviderDepth {
+		lg.WarnLogger.Printf("ignored %d proxy providers of %s: depth limit %d reached", len(sub.Providers), targetURL, maxProviderDepth)
+		sub.Providers = nil
+		return sub, nil
+	}
+
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("%w: target URL: %v", ErrInvalidInput, err)
+	}
+	// sub.Providers is shared with concurrent callers, so fill in a copy.
+	sub.Providers = slices.Clone(sub.Providers)
+	g, gctx := errgroup.WithContext(ctx)
+	for i := range sub.Providers {
+		p := &sub.Providers[i]
+		g.Go(func() error {
+			u, err := base.Parse(p.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				p.Skipped = []Diagnostic{sourceDiagnostic(p.URL, p.Name, fmt.Errorf("invalid url %q", p.URL))}
+				return nil
+			}
+			provided, err := s.resolve(gctx, u.String(), depth+1)
+			if err != nil {
+				if gctx.Err() != nil {
+					return gctx.Err()
+				}
+				p.Skipped = []Diagnostic{sourceDiagnostic(u.String(), p.Name, err)}
+				return nil
+			}
+			p.Proxies, p.Skipped = provided.allProxies(), provided.allSkipped()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return Subscription{}, fmt.Errorf("context canceled")
+	}
+	return sub, nil
+}
+
+// fetch downloads and parses targetURL. Concurrent calls for the same URL
+// share a single upstream request; the returned value must not be modified.
+func (s *Service) fetch(ctx context.Context, targetURL string) (Subscription, error) {
 	resultCh := s.group.DoChan(targetURL, func() (any, error) {
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
 		if err != nil {
-			return "", fmt.Errorf("%w: craft request failed: %v", ErrInvalidInput, err)
+			return Subscription{}, fmt.Errorf("%w: craft request failed: %v", ErrInvalidInput, err)
 		}
 
 		resp, err := s.client.Do(req)
 		if err != nil {
-			return "", fmt.Errorf("%w: fetch upstream failed: %v", ErrUpstream, err)
+			return Subscription{}, fmt.Errorf("%w: fetch upstream failed: %v", ErrUpstream, err)
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("%w: upstream returned %d", ErrUpstream, resp.StatusCode)
+			return Subscription{}, fmt.Errorf("%w: upstream returned %d", ErrUpstream, resp.StatusCode)
 		}
-		proxies, bufSize, err := ParseProxiesFromReader(resp.Body)
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxYAMLBytes))
 		if err != nil {
-			return "", fmt.Errorf("%w: %v", ErrUpstream, err)
+			return Subscription{}, fmt.Errorf("%w: read upstream failed: %v", ErrUpstream, err)
+		}
+		sub, err := parseSubscription(resp.Header.Get("Content-Type"), body)
+		if err != nil {
+			return Subscription{}, fmt.Errorf("%w: %v", ErrUpstream, err)
+		}
+		// Nothing shares sub yet, so record where its entries come from.
+		for i := range sub.Proxies {
+			sub.Proxies[i].source = targetURL
 		}
-		if len(proxies) == 0 {
-			return "", fmt.Errorf("%w: no valid proxies found", ErrNoValidProxies)
+		for i := range sub.Skipped {
+			sub.Skipped[i].Source = targetURL
 		}
-		return base64Encode(proxies, bufSize), nil
+		return sub, nil
 	})
 
 	select {
 	case <-ctx.Done():
-		return "", fmt.Errorf("context canceled")
+		return Subscription{}, fmt.Errorf("context canceled")
 	case res := <-resultCh:
 		if res.Err != nil {
-			return "", res.Err
+			return Subscription{}, res.Err
 		}
-		encoded, ok := res.Val.(string)
+		sub, ok := res.Val.(Subscription)
 		if !ok {
-			return "", fmt.Errorf("internal error: unexpected type %T of result", res.Val)
+			return Subscription{}, fmt.Errorf("internal error: unexpected type %T of result", res.Val)
 		}
-		return encoded, nil
+		return sub, nil
 	}
 }
 
@@ -66,3 +66,41 @@ func (c *blockingHTTPClient) callCount() int {
 	defer c.mu.Unlock()
 	return c.calls
 }
+
+// routingHTTPClient serves canned bodies keyed by request URL and answers
+// 404 for anything else.
+type routingHTTPClient struct {
+	bodies map[string]string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (c *routingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, req.URL.String())
+	c.mu.Unlock()
+
+	body, ok := c.bodies[req.URL.String()]
+	if !ok {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     make(http.Header),
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+// sourceURL is the upstream fetched by tests that serve a single document.
+const sourceURL = "https://source.example/config"
+
+// newSourceService builds a Service whose client serves body at sourceURL on
+// every request, so one Service can process several requests.
+func newSourceService(body string, opts ...ServiceOption) *Service {
+	return NewService(&routingHTTPClient{bodies: map[string]string{sourceURL: body}}, opts...)
+}
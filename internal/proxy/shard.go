@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"cmp"
+	"slices"
+)
+
+// shardProxies returns the n items ranked highest for token by rendezvous
+// hashing, in their original order. Every client gets a stable subset;
+// adding or removing an upstream proxy only changes the subsets that
+// contain it.
+func shardProxies(items []ProxyItem, n int, token string) []ProxyItem {
+	if n <= 0 || n >= len(items) {
+		return items
+	}
+
+	scores := make([]uint64, len(items))
+	ranked := make([]int, len(items))
+	for i, it := range items {
+		scores[i], ranked[i] = proxyHash(token, it), i
+	}
+	slices.SortFunc(ranked, func(a, b int) int {
+		return cmp.Or(cmp.Compare(scores[b], scores[a]), cmp.Compare(a, b))
+	})
+	picked := ranked[:n]
+	slices.Sort(picked)
+
+	result := make([]ProxyItem, 0, n)
+	for _, i := range picked {
+		result = append(result, items[i])
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestShardProxies(t *testing.T) {
+	var pool []ProxyItem
+	for i := range 20 {
+		pool = append(pool, ProxyItem{Name: fmt.Sprint(i), Server: fmt.Sprintf("%d.example", i), Port: 443})
+	}
+	names := func(items []ProxyItem) []string {
+		var names []string
+		for _, it := range items {
+			names = append(names, it.Name)
+		}
+		return names
+	}
+
+	const n = 3
+	picks := map[string]int{}
+	for c := range 300 {
+		token := fmt.Sprintf("client-%d", c)
+		subset := shardProxies(pool, n, token)
+		if len(subset) != n {
+			t.Fatalf("want %d proxies, got %d", n, len(subset))
+		}
+		if !slices.Equal(names(subset), names(shardProxies(pool, n, token))) {
+			t.Fatalf("subset for %s is not stable", token)
+		}
+		for _, it := range subset {
+			picks[it.Name]++
+		}
+
+		// Removing a proxy outside the subset must not change it; removing
+		// one inside replaces only that proxy.
+		for i, it := range pool {
+			reduced := shardProxies(slices.Delete(slices.Clone(pool), i, i+1), n, token)
+			kept := 0
+			for _, r := range reduced {
+				if slices.Contains(names(subset), r.Name) {
+					kept++
+				}
+			}
+			want := n
+			if slices.Contains(names(subset), it.Name) {
+				want = n - 1
+			}
+			if kept != want {
+				t.Fatalf("%s: removing %s kept %d of the subset, want %d", token, it.Name, kept, want)
+			}
+		}
+	}
+
+	// 900 picks over 20 proxies average 45 each.
+	for _, it := range pool {
+		if got := picks[it.Name]; got < 20 || got > 80 {
+			t.Fatalf("uneven spread: proxy %s picked %d times", it.Name, got)
+		}
+	}
+
+	if got := shardProxies(pool, 0, "x"); len(got) != len(pool) {
+		t.Fatalf("a zero subset must keep everything")
+	}
+}
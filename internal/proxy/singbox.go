@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// singboxOutbound mirrors a sing-box `http` outbound. Other outbound types
+// decode into it as well, keeping only the fields they share.
+type singboxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server"`
+	ServerPort int               `json:"server_port"`
+	Username   string            `json:"username,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	TLS        *singboxTLS       `json:"tls,omitempty"`
+}
+
+type singboxTLS struct {
+	Enabled    bool         `json:"enabled"`
+	ServerName string       `json:"server_name,omitempty"`
+	Insecure   bool         `json:"insecure,omitempty"`
+	ALPN       []string     `json:"alpn,omitempty"`
+	UTLS       *singboxUTLS `json:"utls,omitempty"`
+}
+
+type singboxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type singboxDocument struct {
+	Outbounds []singboxOutbound `json:"outbounds"`
+}
+
+// encodeSingbox renders items as a sing-box configuration fragment holding
+// one `http` outbound per proxy. sing-box cannot pin a certificate
+// fingerprint; see droppedFields.
+func encodeSingbox(items []ProxyItem) (string, error) {
+	doc := singboxDocument{Outbounds: make([]singboxOutbound, 0, len(items))}
+	for _, it := range items {
+		ob := singboxOutbound{
+			Type:       "http",
+			Tag:        displayName(it),
+			Server:     it.Server,
+			ServerPort: it.Port,
+			Username:   it.Username,
+			Password:   it.Password,
+			Headers:    it.Headers,
+		}
+		if it.TLS {
+			ob.TLS = &singboxTLS{Enabled: true, ServerName: it.SNI, Insecure: it.SkipCertVerify, ALPN: it.ALPN}
+			if it.ClientFingerprint != "" {
+				ob.TLS.UTLS = &singboxUTLS{Enabled: true, Fingerprint: it.ClientFingerprint}
+			}
+		}
+		doc.Outbounds = append(doc.Outbounds, ob)
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sing-box document: %v", err)
+	}
+	return string(out) + "\n", nil
+}
+
+// ParseSingboxFromReader parses the `outbounds` array of a sing-box
+// configuration. `http` outbounds become ProxyItems; other proxy outbounds
+// are kept with their type so that validation can report them, while
+// outbounds without a server (selector, direct, ...) are ignored. Outbounds
+// that cannot be decoded are recorded in Skipped.
+func ParseSingboxFromReader(r io.Reader) (Subscription, error) {
+	var (
+		sub Subscription
+		doc struct {
+			Outbounds []json.RawMessage `json:"outbounds"`
+		}
+	)
+	if err := json.NewDecoder(io.LimitReader(r, maxYAMLBytes)).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return sub, fmt.Errorf("upstream empty")
+		}
+		return sub, fmt.Errorf("failed to read outbounds: %v", err)
+	}
+
+	sub.Proxies = make([]ProxyItem, 0, len(doc.Outbounds))
+	for i, raw := range doc.Outbounds {
+		var ob singboxOutbound
+		if err := json.Unmarshal(raw, &ob); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field == "server_port" {
+				err = keyError("server_port", err)
+			}
+			sub.Skipped = append(sub.Skipped, entryDiagnostic(i, ob.Tag, err))
+			continue
+		}
+		if ob.Server == "" {
+			continue
+		}
+		it := ProxyItem{
+			Username: ob.Username,
+			Password: ob.Password,
+			Server:   ob.Server,
+			Port:     ob.ServerPort,
+			Type:     ob.Type,
+			Name:     ob.Tag,
+			Headers:  ob.Headers,
+			index:    i,
+		}
+		if ob.TLS != nil && ob.TLS.Enabled {
+			it.TLS, it.SNI = true, ob.TLS.ServerName
+			it.SkipCertVerify, it.ALPN = ob.TLS.Insecure, ob.TLS.ALPN
+			if ob.TLS.UTLS != nil && ob.TLS.UTLS.Enabled {
+				it.ClientFingerprint = ob.TLS.UTLS.Fingerprint
+			}
+		}
+		sub.Proxies = append(sub.Proxies, it)
+	}
+	return sub, nil
+}
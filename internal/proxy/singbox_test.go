@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSingboxFromReader(t *testing.T) {
+	body := `{
+  "log": {"level": "info"},
+  "outbounds": [
+    {"type": "selector", "tag": "select", "outbounds": ["hk"]},
+    {
+      "type": "http",
+      "tag": "hk",
+      "server": "hk.example",
+      "server_port": 443,
+      "username": "u",
+      "password": "p",
+      "tls": {"enabled": true, "server_name": "sni.example"}
+    },
+    {"type": "http", "tag": "plain", "server": "plain.example", "server_port": 8080},
+    {"type": "shadowsocks", "tag": "ss", "server": "ss.example", "server_port": 8388, "method": "aes-128-gcm"},
+    {"type": "direct", "tag": "direct"}
+  ]
+}`
+
+	sub, err := ParseSingboxFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseSingboxFromReader returned error: %v", err)
+	}
+	items := sub.Proxies
+	want := []ProxyItem{
+		{Username: "u", Password: "p", Server: "hk.example", Port: 443, TLS: true, Type: "http", Name: "hk", SNI: "sni.example", index: 1},
+		{Server: "plain.example", Port: 8080, Type: "http", Name: "plain", index: 2},
+		{Server: "ss.example", Port: 8388, Type: "shadowsocks", Name: "ss", index: 3},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("want %d items, got %d: %+v", len(want), len(items), items)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(items[i], want[i]) {
+			t.Fatalf("item %d: want %+v got %+v", i, want[i], items[i])
+		}
+	}
+
+	// Our own output must be accepted as input.
+	encoded, err := encodeSingbox(items[:1])
+	if err != nil {
+		t.Fatalf("encodeSingbox returned error: %v", err)
+	}
+	again, err := ParseSingboxFromReader(strings.NewReader(encoded))
+	want[0].index = 0
+	if err != nil || len(again.Proxies) != 1 || !reflect.DeepEqual(again.Proxies[0], want[0]) {
+		t.Fatalf("round trip mismatch: %+v, %v", again, err)
+	}
+}
+
+func TestParseSingboxFromReader_BadPort(t *testing.T) {
+	body := `{"outbounds": [
+    {"type": "http", "tag": "bad", "server": "a.example", "server_port": "abc"},
+    {"type": "http", "tag": "ok", "server": "b.example", "server_port": 443}
+  ]}`
+
+	sub, err := ParseSingboxFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseSingboxFromReader returned error: %v", err)
+	}
+	if len(sub.Proxies) != 1 || len(sub.Skipped) != 1 {
+		t.Fatalf("want 1 proxy and 1 skipped entry, got %+v", sub)
+	}
+	if d := sub.Skipped[0]; d.Index != 0 || d.Reason != ReasonBadPort || d.Field != "server_port" {
+		t.Fatalf("want bad-port on server_port of entry 0, got %+v", d)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"regexp"
+)
+
+// inputFormat identifies the syntax of an upstream subscription.
+type inputFormat int
+
+const (
+	inputClash inputFormat = iota
+	inputJSON
+	inputURIList
+	inputBase64URIList
+)
+
+func (f inputFormat) String() string {
+	switch f {
+	case inputJSON:
+		return "json"
+	case inputURIList:
+		return "uri-list"
+	case inputBase64URIList:
+		return "base64-uri-list"
+	default:
+		return "clash"
+	}
+}
+
+// sniffInput guesses the format of body. An explicit YAML or JSON
+// Content-Type is trusted when the body agrees with it; otherwise the body
+// alone decides and anything unrecognised is treated as Clash YAML.
+func sniffInput(contentType string, body []byte) inputFormat {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")))
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return inputClash
+	case "application/json":
+		if json.Valid(trimmed) {
+			return inputJSON
+		}
+	}
+
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return inputJSON
+	}
+	if uriLine.Match(firstLine(trimmed)) {
+		return inputURIList
+	}
+	if decoded, ok := decodeBase64(trimmed); ok && uriLine.Match(firstLine(decoded)) {
+		return inputBase64URIList
+	}
+	return inputClash
+}
+
+// uriLine matches a line starting with a URI scheme. A YAML key whose value
+// is a URL, such as `subscription-url: https://...`, does not match.
+var uriLine = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://`)
+
+// firstLine returns the first line of b that is neither blank nor a comment.
+func firstLine(b []byte) []byte {
+	for line := range bytes.SplitSeq(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' {
+			return line
+		}
+	}
+	return nil
+}
+
+// parseSubscription dispatches body to the parser matching its sniffed
+// format.
+func parseSubscription(contentType string, body []byte) (Subscription, error) {
+	switch sniffInput(contentType, body) {
+	case inputURIList, inputBase64URIList:
+		return ParseURIListFromReader(bytes.NewReader(body))
+	case inputJSON:
+		return ParseSingboxFromReader(bytes.NewReader(body))
+	default:
+		return ParseClashFromReader(bytes.NewReader(body))
+	}
+}
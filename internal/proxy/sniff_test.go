@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestSniffInput(t *testing.T) {
+	uris := "https://u:p@a.example:443#a\nhttps://u:p@b.example:443#b\n"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        inputFormat
+	}{
+		{name: "clash", body: "proxies:\n- name: a\n", want: inputClash},
+		{name: "clash flow mapping", body: "{proxies: [{name: a}]}", want: inputClash},
+		{name: "yaml content type", contentType: "text/yaml; charset=utf-8", body: `{"proxies": []}`, want: inputClash},
+		{name: "json", body: "\xef\xbb\xbf  {\"outbounds\": []}", want: inputJSON},
+		{name: "json content type", contentType: "application/json", body: `{"outbounds": []}`, want: inputJSON},
+		{name: "uri list", body: "# comment\n" + uris, want: inputURIList},
+		{name: "clash with url value", body: "subscription-url: https://x\nproxies: []\n", want: inputClash},
+		{name: "base64 uri list", contentType: "text/plain", body: base64.StdEncoding.EncodeToString([]byte(uris)), want: inputBase64URIList},
+		{name: "truncated", body: "p", want: inputClash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffInput(tt.contentType, []byte(tt.body)); got != tt.want {
+				t.Fatalf("want %s got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SortMode selects the order of the emitted proxies.
+type SortMode string
+
+const (
+	// SortUpstream keeps the order of the upstream documents.
+	SortUpstream SortMode = "upstream"
+	// SortName orders proxies by their upstream name.
+	SortName SortMode = "name"
+	// SortServer orders proxies by server, then port.
+	SortServer SortMode = "server"
+	// SortPort orders proxies by port, then server.
+	SortPort SortMode = "port"
+	// SortShuffle orders proxies pseudo-randomly, seeded by the client token,
+	// which requests must therefore supply. The same token always yields the
+	// same order, and adding or removing a proxy does not move the others
+	// relative to each other.
+	SortShuffle SortMode = "shuffle"
+)
+
+// ParseSortMode maps a user supplied sort name onto a SortMode. An empty
+// name yields SortUpstream.
+func ParseSortMode(s string) (SortMode, error) {
+	switch mode := SortMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return SortUpstream, nil
+	case SortUpstream, SortName, SortServer, SortPort, SortShuffle:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: unknown sort mode %q", ErrInvalidInput, s)
+	}
+}
+
+// sortProxies orders items in place according to mode. Equal items keep
+// their upstream order.
+func sortProxies(items []ProxyItem, mode SortMode, token string) {
+	switch mode {
+	case SortName:
+		slices.SortStableFunc(items, func(a, b ProxyItem) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	case SortServer:
+		slices.SortStableFunc(items, func(a, b ProxyItem) int {
+			return cmp.Or(strings.Compare(a.Server, b.Server), cmp.Compare(a.Port, b.Port))
+		})
+	case SortPort:
+		slices.SortStableFunc(items, func(a, b ProxyItem) int {
+			return cmp.Or(cmp.Compare(a.Port, b.Port), strings.Compare(a.Server, b.Server))
+		})
+	case SortShuffle:
+		type keyed struct {
+			hash uint64
+			item ProxyItem
+		}
+		shuffled := make([]keyed, len(items))
+		for i, it := range items {
+			shuffled[i] = keyed{hash: proxyHash(token, it), item: it}
+		}
+		slices.SortStableFunc(shuffled, func(a, b keyed) int {
+			return cmp.Compare(a.hash, b.hash)
+		})
+		for i, k := range shuffled {
+			items[i] = k.item
+		}
+	}
+}
+
+// proxyHash mixes token with the endpoint of it, ignoring the display name
+// so that upstream renames do not reshuffle the list.
+func proxyHash(token string, it ProxyItem) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(token))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(it.Server)))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(it.Port)))
+	h.Write([]byte{0})
+	h.Write([]byte(it.Username))
+
+	// FNV alone mixes short inputs poorly; finish with the murmur3 finalizer
+	// so that scores compare fairly across tokens.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortProxies(t *testing.T) {
+	base := []ProxyItem{
+		{Name: "c", Server: "b.example", Port: 443},
+		{Name: "a", Server: "c.example", Port: 80},
+		{Name: "b", Server: "a.example", Port: 8443},
+		{Name: "a", Server: "a.example", Port: 443},
+	}
+	order := func(items []ProxyItem) []string {
+		var names []string
+		for _, it := range items {
+			names = append(names, it.Name+"@"+it.Server)
+		}
+		return names
+	}
+
+	tests := []struct {
+		mode SortMode
+		want []string
+	}{
+		{mode: SortUpstream, want: []string{"c@b.example", "a@c.example", "b@a.example", "a@a.example"}},
+		{mode: SortName, want: []string{"a@c.example", "a@a.example", "b@a.example", "c@b.example"}},
+		{mode: SortServer, want: []string{"a@a.example", "b@a.example", "c@b.example", "a@c.example"}},
+		{mode: SortPort, want: []string{"a@c.example", "a@a.example", "c@b.example", "b@a.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			items := slices.Clone(base)
+			sortProxies(items, tt.mode, "")
+			if got := order(items); !slices.Equal(got, tt.want) {
+				t.Fatalf("want %v got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSortProxiesShuffle(t *testing.T) {
+	var base []ProxyItem
+	for _, server := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		base = append(base, ProxyItem{Name: server, Server: server + ".example", Port: 443})
+	}
+	shuffle := func(items []ProxyItem, token string) []string {
+		items = slices.Clone(items)
+		sortProxies(items, SortShuffle, token)
+		var names []string
+		for _, it := range items {
+			names = append(names, it.Name)
+		}
+		return names
+	}
+
+	first := shuffle(base, "alice")
+	if !slices.Equal(first, shuffle(base, "alice")) {
+		t.Fatalf("shuffle must be deterministic for a token")
+	}
+	if slices.Equal(first, shuffle(base, "bob")) && slices.Equal(first, shuffle(base, "carol")) {
+		t.Fatalf("different tokens should yield different orders")
+	}
+
+	// Dropping a proxy keeps the relative order of the others.
+	reduced := shuffle(base[1:], "alice")
+	if want := slices.DeleteFunc(slices.Clone(first), func(s string) bool { return s == "a" }); !slices.Equal(reduced, want) {
+		t.Fatalf("removing a proxy reshuffled the rest: want %v got %v", want, reduced)
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// policyNameReplacer strips the characters that would break the
+// `Name = type, ...` line syntax shared by Surge and Loon.
+var policyNameReplacer = strings.NewReplacer(",", " ", "=", "-", "\r", " ", "\n", " ")
+
+// policyName returns a display name safe to use on the left-hand side of a
+// Surge or Loon proxy line.
+func policyName(it ProxyItem) string {
+	return strings.TrimSpace(policyNameReplacer.Replace(displayName(it)))
+}
+
+// policyQuote wraps s in double quotes when it would otherwise be split or
+// trimmed by the Surge/Loon parameter parser.
+func policyQuote(s string) string {
+	if s == "" || strings.ContainsAny(s, ",\"") || strings.TrimSpace(s) != s {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// policyType returns the Surge/Loon proxy type of it.
+func policyType(it ProxyItem) string {
+	switch {
+	case it.Type == TypeSOCKS5 && it.TLS:
+		return "socks5-tls"
+	case it.Type == TypeSOCKS5:
+		return "socks5"
+	case it.TLS:
+		return "https"
+	default:
+		return "http"
+	}
+}
+
+// encodeSurge renders items as Surge `[Proxy]` lines. The output has no
+// section header so it can be consumed through `policy-path` as well.
+// Surge documents no proxy parameter for custom CONNECT headers, so Headers
+// are left out rather than written under a guessed key; droppedFields
+// reports them.
+func encodeSurge(items []ProxyItem) string {
+	var sb strings.Builder
+	for _, it := range items {
+		params := []string{policyType(it), it.Server, strconv.Itoa(it.Port)}
+		if it.Username != "" {
+			params = append(params, policyQuote(it.Username), policyQuote(it.Password))
+		}
+		if it.TLS && it.SNI != "" {
+			params = append(params, "sni="+it.SNI)
+		}
+		if it.TLS && it.SkipCertVerify {
+			params = append(params, "skip-cert-verify=true")
+		}
+		if it.TLS && it.Fingerprint != "" {
+			params = append(params, "server-cert-fingerprint-sha256="+it.Fingerprint)
+		}
+		sb.WriteString(policyName(it))
+		sb.WriteString(" = ")
+		sb.WriteString(strings.Join(params, ", "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// encodeLoon renders items as Loon `[Proxy]` lines. Loon expects the
+// password to always be quoted.
+func encodeLoon(items []ProxyItem) string {
+	var sb strings.Builder
+	for _, it := range items {
+		params := []string{policyType(it), it.Server, strconv.Itoa(it.Port)}
+		if it.Username != "" {
+			params = append(params, policyQuote(it.Username), strconv.Quote(it.Password))
+		}
+		if it.TLS && it.SNI != "" {
+			params = append(params, "sni="+it.SNI)
+		}
+		if it.TLS && it.SkipCertVerify {
+			params = append(params, "skip-cert-verify=true")
+		}
+		if it.TLS && it.Fingerprint != "" {
+			params = append(params, "tls-cert-sha256="+it.Fingerprint)
+		}
+		sb.WriteString(policyName(it))
+		sb.WriteString(" = ")
+		sb.WriteString(strings.Join(params, ","))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -1,17 +1,47 @@
 package proxy
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
+	goyaml "github.com/goccy/go-yaml"
 	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
 )
 
 // maxYAMLBytes is the maximum number of bytes we will read from the
 // upstream YAML document. Tests may adjust this value.
 var maxYAMLBytes int64 = 1 << 20 // 1 MiB
 
+// readYAMLFile parses at most maxYAMLBytes of r into a YAML AST.
+func readYAMLFile(r io.Reader) (*ast.File, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxYAMLBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxies: %v", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, fmt.Errorf("upstream empty")
+	}
+	f, err := parser.ParseBytes(body, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxies: %v", err)
+	}
+	return f, nil
+}
+
+// lookupNode returns the value of the top-level key in f. A missing key is
+// reported as goyaml.ErrNotFoundNode.
+func lookupNode(f *ast.File, key string) (ast.Node, error) {
+	path, err := goyaml.PathString("$." + key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create go-yaml.Path: %v", err)
+	}
+	return path.FilterFile(f)
+}
+
 // nodeToString tries to extract a stable string representation from the
 // AST node. For scalar nodes it prefers the typed value when present and
 // falls back to the node.String() text.
@@ -37,6 +67,52 @@ func nodeToString(n ast.Node) (string, error) {
 	return strings.TrimSpace(_str), nil
 }
 
+// nodeToStringList converts a sequence of scalars into strings. A single
+// scalar is accepted as a one element list.
+func nodeToStringList(n ast.Node) ([]string, error) {
+	if n == nil {
+		return nil, fmt.Errorf("nil node")
+	}
+	seq, ok := n.(ast.ArrayNode)
+	if !ok {
+		s, err := nodeToString(n)
+		if err != nil || s == "" {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+	var result []string
+	for iter := seq.ArrayRange(); iter.Next(); {
+		s, err := nodeToString(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// nodeToStringMap converts a mapping of scalars into a map. Keys are kept
+// as written.
+func nodeToStringMap(n ast.Node) (map[string]string, error) {
+	if n == nil {
+		return nil, fmt.Errorf("nil node")
+	}
+	mnode, ok := n.(*ast.MappingNode)
+	if !ok {
+		return nil, fmt.Errorf("want a mapping, got %T", n)
+	}
+	result := make(map[string]string)
+	for iter := mnode.MapRange(); iter.Next(); {
+		v, err := nodeToString(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		result[strings.TrimSpace(iter.Key().String())] = v
+	}
+	return result, nil
+}
+
 func nodeToInt(n ast.Node) (int, error) {
 	if n == nil {
 		return 0, fmt.Errorf("nil node")